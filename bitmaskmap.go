@@ -74,13 +74,25 @@ func (m *BitmaskMap) ReadFromFile(path string) {
 	log.Println("read bitmask:", path)
 }
 
-// Print -- print debug stats
-func (m BitmaskMap) Print() {
+// Sizes -- total elements in each mask, keyed by mask name
+func (m BitmaskMap) Sizes() map[string]uint64 {
+	sizes := make(map[string]uint64)
 	k := reflect.TypeOf(m)
 	v := reflect.ValueOf(m)
 	for i := 0; i < k.NumField(); i++ {
 		key := k.Field(i).Name
 		val := v.Field(i).Interface()
-		fmt.Printf("%s: %v\n", key, (val.(*Bitmask)).Len())
+		sizes[key] = (val.(*Bitmask)).Len()
+	}
+	return sizes
+}
+
+// Print -- print debug stats
+func (m BitmaskMap) Print() {
+	sizes := m.Sizes()
+	k := reflect.TypeOf(m)
+	for i := 0; i < k.NumField(); i++ {
+		key := k.Field(i).Name
+		fmt.Printf("%s: %v\n", key, sizes[key])
 	}
 }
@@ -29,52 +29,66 @@ func cacheFlush(db *leveldb.DB, batch *leveldb.Batch, sync bool) {
 
 	err := db.Write(batch, writeOpts)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	batch.Reset()
 }
 
-func cacheLookupNodeByID(db *leveldb.DB, id int64) (map[string]string, error) {
+func cacheLookupNodeByID(db *leveldb.DB, id int64) (latLon, error) {
 	stringid := strconv.FormatInt(id, 10)
 
 	data, err := db.Get([]byte(stringid), nil)
-	if err != nil {
+	if err == leveldb.ErrNotFound {
 		log.Println("[warn] fetch failed for node ID:", stringid)
-		return make(map[string]string, 0), err
+		return latLon{}, err
+	} else if err != nil {
+		fatal(err)
 	}
 
 	return bytesToLatLon(data), nil
 }
 
-func cacheLookupNodes(db *leveldb.DB, way *osmpbf.Way) ([]map[string]string, error) {
+// lookup the latlons of the nodes of a way, the IDs of any
+// nodes which were not found are returned separately.
+// note: only nodes missing from the cache are missing, other errors are fatal
+func cacheLookupNodes(db *leveldb.DB, way *osmpbf.Way) ([]latLon, []int64) {
 
-	var container []map[string]string
+	container := make([]latLon, 0, len(way.NodeIDs))
+	var missing []int64
 
 	for _, each := range way.NodeIDs {
 		stringid := strconv.FormatInt(each, 10)
 
 		data, err := db.Get([]byte(stringid), nil)
-		if err != nil {
-			log.Println("[warn] denormalize failed for way:", way.ID, "node not found:", stringid)
-			return make([]map[string]string, 0), err
+		if err == leveldb.ErrNotFound {
+			missing = append(missing, each)
+			continue
+		} else if err != nil {
+			fatal(err)
 		}
 
 		container = append(container, bytesToLatLon(data))
 	}
 
-	return container, nil
+	if len(missing) > 0 {
+		log.Println("[warn] denormalize failed for way:", way.ID, "nodes not found:", missing)
+	}
+
+	return container, missing
 }
 
-func cacheLookupWayNodes(db *leveldb.DB, wayid int64) ([]map[string]string, error) {
+func cacheLookupWayNodes(db *leveldb.DB, wayid int64) ([]latLon, []int64, error) {
 
 	// prefix the key with 'W' to differentiate it from node ids
 	stringid := "W" + strconv.FormatInt(wayid, 10)
 
 	// look up way bytes
 	reldata, err := db.Get([]byte(stringid), nil)
-	if err != nil {
+	if err == leveldb.ErrNotFound {
 		log.Println("[warn] lookup failed for way:", wayid, "noderefs not found:", stringid)
-		return make([]map[string]string, 0), err
+		return make([]latLon, 0), nil, err
+	} else if err != nil {
+		fatal(err)
 	}
 
 	// generate a way object
@@ -83,5 +97,6 @@ func cacheLookupWayNodes(db *leveldb.DB, wayid int64) ([]map[string]string, erro
 		NodeIDs: bytesToIDSlice(reldata),
 	}
 
-	return cacheLookupNodes(db, way)
+	latlons, missing := cacheLookupNodes(db, way)
+	return latlons, missing, nil
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/qedus/osmpbf"
+	"github.com/stretchr/testify/assert"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestCacheLookupMissing(t *testing.T) {
+
+	var db = openLevelDB(t.TempDir())
+	defer db.Close()
+
+	batch := new(leveldb.Batch)
+	cacheQueueNode(batch, &osmpbf.Node{ID: 1, Lat: 1, Lon: 2})
+	cacheQueueNode(batch, &osmpbf.Node{ID: 2, Lat: 3, Lon: 4})
+	cacheQueueWay(batch, &osmpbf.Way{ID: 10, NodeIDs: []int64{1, 99, 2}})
+	cacheFlush(db, batch, false)
+
+	// nodes missing from the cache are returned separately
+	latlons, missing := cacheLookupNodes(db, &osmpbf.Way{ID: 11, NodeIDs: []int64{1, 98, 2}})
+	assert.Equal(t, []latLon{{Lat: 1, Lon: 2}, {Lat: 3, Lon: 4}}, latlons)
+	assert.Equal(t, []int64{98}, missing)
+
+	latlons, missing, err := cacheLookupWayNodes(db, 10)
+	assert.Nil(t, err)
+	assert.Len(t, latlons, 2)
+	assert.Equal(t, []int64{99}, missing)
+
+	// a way missing from the cache
+	_, _, err = cacheLookupWayNodes(db, 12)
+	assert.Equal(t, leveldb.ErrNotFound, err)
+	_, err = cacheLookupNodeByID(db, 97)
+	assert.Equal(t, leveldb.ErrNotFound, err)
+}
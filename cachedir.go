@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// version of the on-disk cache layout, bump this whenever the encoding
+// of the values stored in leveldb changes.
+const cacheFormat = 1
+
+// files written to the cache directory alongside the leveldb files
+const (
+	cacheMarkerFile = "PBF2JSON"
+	cacheLockFile   = "pbf2json.lock"
+)
+
+// cacheDir - a directory holding the leveldb cache for a single run
+type cacheDir struct {
+	Path    string
+	created bool // the directory was created by this run
+	keep    bool // leave the cache on disk when the run ends
+	foreign bool // the directory holds a cache this run refused to use
+	lock    string
+}
+
+// cacheMarker - describes the contents of a cache directory
+type cacheMarker struct {
+	Format int          `json:"format"`
+	Inputs []cacheInput `json:"inputs"`
+}
+
+// cacheInput - identifies an input file the cache was generated from
+type cacheInput struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// openCacheDir - select and lock the cache directory for this run.
+//
+// By default a unique directory is created in the system temp dir. When
+// a path is specified which is empty (or doesn't exist yet), or already
+// holds a pbf2json cache, it is used as-is. Any other existing directory
+// (such as '/tmp') is used as the parent of a unique directory.
+func openCacheDir(path string, keep bool) *cacheDir {
+	c := &cacheDir{keep: keep}
+
+	if len(path) < 1 {
+		c.Path, c.created = tempCacheDir(os.TempDir()), true
+	} else if entries, err := ioutil.ReadDir(path); os.IsNotExist(err) {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			fatal(err)
+		}
+		c.Path, c.created = path, true
+	} else if err != nil {
+		fatal(err)
+	} else if len(entries) == 0 || isCacheDir(path) {
+		c.Path = path
+	} else {
+		c.Path, c.created = tempCacheDir(path), true
+	}
+
+	// take an exclusive lock, two runs sharing a cache corrupt each other
+	c.lock = filepath.Join(c.Path, cacheLockFile)
+	lock, err := os.OpenFile(c.lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		pid, _ := ioutil.ReadFile(c.lock)
+		fatalf("[error] cache directory %s is locked by another process (pid %s), remove %s if that process is no longer running",
+			c.Path, strings.TrimSpace(string(pid)), c.lock)
+	} else if err != nil {
+		fatal(err)
+	}
+	fmt.Fprintln(lock, os.Getpid())
+	lock.Close()
+
+	log.Println("[info] using cache directory:", c.Path)
+	return c
+}
+
+// create a uniquely named cache directory inside base
+func tempCacheDir(base string) string {
+	path, err := ioutil.TempDir(base, "pbf2json-")
+	if err != nil {
+		fatal(err)
+	}
+	return path
+}
+
+// check if a directory was previously used as a pbf2json cache
+func isCacheDir(path string) bool {
+	for _, name := range []string{cacheMarkerFile, cacheLockFile} {
+		if _, err := os.Stat(filepath.Join(path, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Claim - verify that an existing cache was generated from the same input
+// files using a compatible format, then record the inputs for future runs.
+func (c *cacheDir) Claim(paths []string, files []*os.File) {
+	marker := cacheMarker{Format: cacheFormat}
+	for i, file := range files {
+		info, err := file.Stat()
+		if err != nil {
+			fatal(err)
+		}
+		input := cacheInput{Path: paths[i], Size: info.Size(), Modified: info.ModTime().UTC()}
+		if paths[i] == "-" {
+			input.Modified = time.Time{} // spooled copies differ between runs
+		} else if abs, err := filepath.Abs(paths[i]); err == nil {
+			input.Path = abs
+		}
+		marker.Inputs = append(marker.Inputs, input)
+	}
+
+	markerPath := filepath.Join(c.Path, cacheMarkerFile)
+	if data, err := ioutil.ReadFile(markerPath); err == nil {
+		var existing cacheMarker
+		if err := json.Unmarshal(data, &existing); err != nil || existing.Format != cacheFormat {
+			c.foreign = true
+			fatalf("[error] cache directory %s holds a cache in an incompatible format, remove it or choose another -leveldb path", c.Path)
+		}
+		if !sameInputs(existing.Inputs, marker.Inputs) {
+			c.foreign = true
+			fatalf("[error] cache directory %s holds a cache for different PBF files, remove it or choose another -leveldb path", c.Path)
+		}
+	} else if !os.IsNotExist(err) {
+		fatal(err)
+	}
+
+	data, _ := json.MarshalIndent(marker, "", "  ")
+	if err := ioutil.WriteFile(markerPath, data, 0644); err != nil {
+		fatal(err)
+	}
+}
+
+// check if two lists of inputs refer to the same files
+func sameInputs(a []cacheInput, b []cacheInput) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Path != b[i].Path || a[i].Size != b[i].Size || !a[i].Modified.Equal(b[i].Modified) {
+			return false
+		}
+	}
+	return true
+}
+
+// Close - release the lock and remove the cache, unless it should be kept
+func (c *cacheDir) Close() error {
+	if c.keep || c.foreign {
+		if c.keep {
+			log.Println("[info] cache kept at:", c.Path)
+		}
+		return os.Remove(c.lock)
+	}
+
+	// only remove the directory itself if this run created it
+	if c.created {
+		return os.RemoveAll(c.Path)
+	}
+	entries, _ := ioutil.ReadDir(c.Path)
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(c.Path, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
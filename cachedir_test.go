@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheDirEmpty(t *testing.T) {
+
+	// an empty directory is used as-is, only its contents are removed
+	var dir = t.TempDir()
+	var cache = openCacheDir(dir, false)
+	assert.Equal(t, dir, cache.Path)
+	assert.FileExists(t, filepath.Join(dir, cacheLockFile))
+
+	ioutil.WriteFile(filepath.Join(dir, "CURRENT"), []byte{}, 0644)
+	cache.Close()
+	assert.DirExists(t, dir)
+
+	entries, _ := ioutil.ReadDir(dir)
+	assert.Len(t, entries, 0)
+}
+
+func TestCacheDirMissing(t *testing.T) {
+
+	// a missing directory is created and removed afterwards
+	var dir = filepath.Join(t.TempDir(), "cache")
+	var cache = openCacheDir(dir, false)
+	assert.Equal(t, dir, cache.Path)
+	cache.Close()
+	assert.NoDirExists(t, dir)
+}
+
+func TestCacheDirShared(t *testing.T) {
+
+	// a directory holding other files is used as the parent of a unique directory
+	var dir = t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "unrelated.txt"), []byte{}, 0644)
+
+	var cache = openCacheDir(dir, false)
+	assert.Equal(t, dir, filepath.Dir(cache.Path))
+	cache.Close()
+	assert.NoDirExists(t, cache.Path)
+	assert.FileExists(t, filepath.Join(dir, "unrelated.txt"))
+}
+
+func TestCacheDirKeep(t *testing.T) {
+
+	var dir = filepath.Join(t.TempDir(), "cache")
+	var cache = openCacheDir(dir, true)
+	cache.Claim(nil, nil)
+	cache.Close()
+
+	// the cache is kept but unlocked, it can then be reopened
+	assert.DirExists(t, dir)
+	assert.NoFileExists(t, filepath.Join(dir, cacheLockFile))
+	assert.True(t, isCacheDir(dir))
+
+	var reopened = openCacheDir(dir, false)
+	assert.Equal(t, dir, reopened.Path)
+	reopened.Close()
+}
+
+func TestCacheDirClaim(t *testing.T) {
+
+	var dir = t.TempDir()
+	var pbf, _ = ioutil.TempFile(dir, "input.pbf")
+	defer pbf.Close()
+
+	var cache = openCacheDir(filepath.Join(dir, "cache"), true)
+	cache.Claim([]string{pbf.Name()}, []*os.File{pbf})
+	assert.FileExists(t, filepath.Join(cache.Path, cacheMarkerFile))
+
+	// claiming again with the same inputs succeeds
+	cache.Claim([]string{pbf.Name()}, []*os.File{pbf})
+	assert.False(t, cache.foreign)
+	cache.Close()
+}
+
+func TestSameInputs(t *testing.T) {
+
+	var now = time.Now()
+	var a = []cacheInput{{Path: "/a.pbf", Size: 10, Modified: now}}
+
+	assert.True(t, sameInputs(a, []cacheInput{{Path: "/a.pbf", Size: 10, Modified: now.UTC()}}))
+	assert.False(t, sameInputs(a, []cacheInput{{Path: "/b.pbf", Size: 10, Modified: now}}))
+	assert.False(t, sameInputs(a, []cacheInput{{Path: "/a.pbf", Size: 11, Modified: now}}))
+	assert.False(t, sameInputs(a, []cacheInput{{Path: "/a.pbf", Size: 10, Modified: now.Add(time.Second)}}))
+	assert.False(t, sameInputs(a, append(a, a...)))
+}
@@ -8,13 +8,14 @@ import (
 
 func TestComputeCentroidWithEntranceNode(t *testing.T) {
 
-	var latlons = []map[string]string{
-		map[string]string{"lat": "1", "lon": "2", "entrance": "1"},
+	var latlons = []latLon{
+		{Lat: 1, Lon: 2, Entrance: 1},
 	}
 
 	var centroid, bounds = computeCentroidAndBounds(latlons)
-	assert.Equal(t, "1", centroid["lat"])
-	assert.Equal(t, "2", centroid["lon"])
+	assert.Equal(t, 1.0, centroid.Lat)
+	assert.Equal(t, 2.0, centroid.Lon)
+	assert.Equal(t, "entrance", centroid.Type)
 	assert.Equal(t, +1.0, bounds.North())
 	assert.Equal(t, +1.0, bounds.South())
 	assert.Equal(t, +2.0, bounds.East())
@@ -23,15 +24,15 @@ func TestComputeCentroidWithEntranceNode(t *testing.T) {
 
 func TestComputeCentroidWithMainEntranceNode(t *testing.T) {
 
-	var latlons = []map[string]string{
-		map[string]string{"lat": "0", "lon": "0", "entrance": "1"},
-		map[string]string{"lat": "1", "lon": "2", "entrance": "2"},
-		map[string]string{"lat": "-1", "lon": "-2", "entrance": "1", "wheelchair": "2"},
+	var latlons = []latLon{
+		{Lat: 0, Lon: 0, Entrance: 1},
+		{Lat: 1, Lon: 2, Entrance: 2},
+		{Lat: -1, Lon: -2, Entrance: 1, Wheelchair: 2},
 	}
 
 	var centroid, bounds = computeCentroidAndBounds(latlons)
-	assert.Equal(t, "1", centroid["lat"])
-	assert.Equal(t, "2", centroid["lon"])
+	assert.Equal(t, 1.0, centroid.Lat)
+	assert.Equal(t, 2.0, centroid.Lon)
 	assert.Equal(t, +1.0, bounds.North())
 	assert.Equal(t, -1.0, bounds.South())
 	assert.Equal(t, +2.0, bounds.East())
@@ -40,14 +41,14 @@ func TestComputeCentroidWithMainEntranceNode(t *testing.T) {
 
 func TestComputeCentroidWithAccessibleEntranceNode(t *testing.T) {
 
-	var latlons = []map[string]string{
-		map[string]string{"lat": "0", "lon": "0", "entrance": "1"},
-		map[string]string{"lat": "-1", "lon": "-2", "entrance": "1", "wheelchair": "2"},
+	var latlons = []latLon{
+		{Lat: 0, Lon: 0, Entrance: 1},
+		{Lat: -1, Lon: -2, Entrance: 1, Wheelchair: 2},
 	}
 
 	var centroid, bounds = computeCentroidAndBounds(latlons)
-	assert.Equal(t, "-1", centroid["lat"])
-	assert.Equal(t, "-2", centroid["lon"])
+	assert.Equal(t, -1.0, centroid.Lat)
+	assert.Equal(t, -2.0, centroid.Lon)
 	assert.Equal(t, +0.0, bounds.North())
 	assert.Equal(t, -1.0, bounds.South())
 	assert.Equal(t, +0.0, bounds.East())
@@ -56,13 +57,13 @@ func TestComputeCentroidWithAccessibleEntranceNode(t *testing.T) {
 
 func TestComputeCentroidWithRegularEntranceNode(t *testing.T) {
 
-	var latlons = []map[string]string{
-		map[string]string{"lat": "0", "lon": "0", "entrance": "1"},
+	var latlons = []latLon{
+		{Lat: 0, Lon: 0, Entrance: 1},
 	}
 
 	var centroid, bounds = computeCentroidAndBounds(latlons)
-	assert.Equal(t, "0", centroid["lat"])
-	assert.Equal(t, "0", centroid["lon"])
+	assert.Equal(t, 0.0, centroid.Lat)
+	assert.Equal(t, 0.0, centroid.Lon)
 	assert.Equal(t, +0.0, bounds.North())
 	assert.Equal(t, +0.0, bounds.South())
 	assert.Equal(t, +0.0, bounds.East())
@@ -71,17 +72,18 @@ func TestComputeCentroidWithRegularEntranceNode(t *testing.T) {
 
 func TestComputeCentroidForClosedPolygon(t *testing.T) {
 
-	var latlons = []map[string]string{
-		map[string]string{"lat": "1", "lon": "1"},
-		map[string]string{"lat": "-1", "lon": "1"},
-		map[string]string{"lat": "-1", "lon": "-1"},
-		map[string]string{"lat": "1", "lon": "-1"},
-		map[string]string{"lat": "1", "lon": "1"},
+	var latlons = []latLon{
+		{Lat: 1, Lon: 1},
+		{Lat: -1, Lon: 1},
+		{Lat: -1, Lon: -1},
+		{Lat: 1, Lon: -1},
+		{Lat: 1, Lon: 1},
 	}
 
 	var centroid, bounds = computeCentroidAndBounds(latlons)
-	assert.Equal(t, "0.0000000", centroid["lat"])
-	assert.Equal(t, "0.0000000", centroid["lon"])
+	assert.InDelta(t, 0.0, centroid.Lat, 0.00000005)
+	assert.InDelta(t, 0.0, centroid.Lon, 0.00000005)
+	assert.Equal(t, "", centroid.Type)
 	assert.Equal(t, +1.0, bounds.North())
 	assert.Equal(t, -1.0, bounds.South())
 	assert.Equal(t, +1.0, bounds.East())
@@ -90,19 +92,19 @@ func TestComputeCentroidForClosedPolygon(t *testing.T) {
 
 func TestComputeCentroidForHillboroPublicLibrary(t *testing.T) {
 
-	var latlons = []map[string]string{
-		map[string]string{"lat": "45.5424694", "lon": "-122.9356798"},
-		map[string]string{"lat": "45.5424261", "lon": "-122.9361523"},
-		map[string]string{"lat": "45.5432827", "lon": "-122.9363111"},
-		map[string]string{"lat": "45.5433259", "lon": "-122.9358387"},
-		map[string]string{"lat": "45.5430581", "lon": "-122.9357890"},
-		map[string]string{"lat": "45.5429060", "lon": "-122.9357608"},
-		map[string]string{"lat": "45.5424694", "lon": "-122.9356798"},
+	var latlons = []latLon{
+		{Lat: 45.5424694, Lon: -122.9356798},
+		{Lat: 45.5424261, Lon: -122.9361523},
+		{Lat: 45.5432827, Lon: -122.9363111},
+		{Lat: 45.5433259, Lon: -122.9358387},
+		{Lat: 45.5430581, Lon: -122.9357890},
+		{Lat: 45.5429060, Lon: -122.9357608},
+		{Lat: 45.5424694, Lon: -122.9356798},
 	}
 
 	var centroid, bounds = computeCentroidAndBounds(latlons)
-	assert.Equal(t, "45.5428760", centroid["lat"])
-	assert.Equal(t, "-122.9359955", centroid["lon"])
+	assert.InDelta(t, 45.5428760, centroid.Lat, 0.00000005)
+	assert.InDelta(t, -122.9359955, centroid.Lon, 0.00000005)
 	assert.Equal(t, +45.5433259, bounds.North())
 	assert.Equal(t, +45.5424261, bounds.South())
 	assert.Equal(t, -122.9356798, bounds.East())
@@ -111,15 +113,15 @@ func TestComputeCentroidForHillboroPublicLibrary(t *testing.T) {
 
 func TestComputeCentroidForOpenLineString(t *testing.T) {
 
-	var latlons = []map[string]string{
-		map[string]string{"lat": "1", "lon": "1"},
-		map[string]string{"lat": "0", "lon": "0"},
-		map[string]string{"lat": "-1", "lon": "-1"},
+	var latlons = []latLon{
+		{Lat: 1, Lon: 1},
+		{Lat: 0, Lon: 0},
+		{Lat: -1, Lon: -1},
 	}
 
 	var centroid, bounds = computeCentroidAndBounds(latlons)
-	assert.Equal(t, "0.0000000", centroid["lat"])
-	assert.Equal(t, "0.0000000", centroid["lon"])
+	assert.InDelta(t, 0.0, centroid.Lat, 0.00000005)
+	assert.InDelta(t, 0.0, centroid.Lon, 0.00000005)
 	assert.Equal(t, +1.0, bounds.North())
 	assert.Equal(t, -1.0, bounds.South())
 	assert.Equal(t, +1.0, bounds.East())
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// cleanup handlers are run once before the process exits, regardless of
+// whether it finished successfully, failed or was interrupted by a signal.
+var cleanups struct {
+	sync.Mutex
+	handlers []func() error
+	running  int32 // set when the handlers start, accessed atomically
+	failed   bool  // set when a handler fails
+}
+
+// addCleanup - register a function to run before the process exits.
+// note: handlers must return errors rather than exiting, an exit while the
+// handlers are running skips the remaining handlers.
+func addCleanup(fn func() error) {
+	cleanups.Lock()
+	defer cleanups.Unlock()
+	cleanups.handlers = append(cleanups.handlers, fn)
+}
+
+// runCleanups - run all registered handlers in reverse order of registration,
+// errors are logged and reported as a failure once every handler has run.
+// Only the first call runs the handlers, later calls return true.
+func runCleanups() bool {
+	if !atomic.CompareAndSwapInt32(&cleanups.running, 0, 1) {
+		return true
+	}
+	cleanups.Lock()
+	defer cleanups.Unlock()
+	for i := len(cleanups.handlers) - 1; i >= 0; i-- {
+		if err := cleanups.handlers[i](); err != nil {
+			log.Println("[error]", err)
+			cleanups.failed = true
+		}
+	}
+	return !cleanups.failed
+}
+
+// cleanupFailed - check if a handler which already ran has failed.
+// note: only call it from a handler, while the handlers are running.
+func cleanupFailed() bool {
+	return cleanups.failed
+}
+
+// exit - run cleanup handlers and then terminate the process, a successful
+// run fails if a handler failed and reports a caught signal.
+// note: only call exit from the main goroutine, the handlers close the files
+// and database which the passes are using. A handler which exits while the
+// handlers are running terminates the process without running the rest.
+func exit(code int) {
+	if atomic.LoadInt32(&cleanups.running) != 0 {
+		log.Println("[warn] exiting before cleanup completed")
+		os.Exit(code)
+	}
+	if code == 0 {
+		code = int(atomic.LoadInt32(&interrupted))
+	}
+	if !runCleanups() && code == 0 {
+		code = 1
+	}
+	os.Exit(code)
+}
+
+// fatal - equivalent to log.Fatal, cleanup handlers are run before exiting
+func fatal(v ...interface{}) {
+	log.Output(2, fmt.Sprint(v...))
+	exit(1)
+}
+
+// fatalf - equivalent to log.Fatalf, cleanup handlers are run before exiting
+func fatalf(format string, v ...interface{}) {
+	log.Output(2, fmt.Sprintf(format, v...))
+	exit(1)
+}
+
+// the exit status of the first signal caught, accessed atomically
+var interrupted int32
+
+// record signals which interrupt the process, the main goroutine stops at the
+// next element and runs the cleanup handlers (see checkInterrupted).
+func handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			log.Println("[warn] caught signal:", sig)
+
+			// mimic the shell convention of reporting a signalled exit as 128+signum
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			atomic.CompareAndSwapInt32(&interrupted, 0, int32(code))
+		}
+	}()
+}
+
+// checkInterrupted - exit if a signal was caught, called by the main goroutine
+// between elements so the cleanup handlers never run during a pass.
+func checkInterrupted() {
+	if code := atomic.LoadInt32(&interrupted); code != 0 {
+		exit(int(code))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCleanups(t *testing.T) {
+	handlers := cleanups.handlers
+	defer func() {
+		cleanups.handlers = handlers
+		atomic.StoreInt32(&cleanups.running, 0)
+		cleanups.failed = false
+	}()
+	cleanups.handlers = nil
+
+	// handlers run in reverse order, a failure doesn't stop the others
+	var order []int
+	var failed []bool
+	addCleanup(func() error { order = append(order, 1); failed = append(failed, cleanupFailed()); return nil })
+	addCleanup(func() error { order = append(order, 2); return errors.New("failed") })
+	addCleanup(func() error { order = append(order, 3); failed = append(failed, cleanupFailed()); return nil })
+	assert.False(t, runCleanups())
+	assert.Equal(t, []int{3, 2, 1}, order)
+
+	// later handlers see the failure
+	assert.Equal(t, []bool{false, true}, failed)
+
+	// only the first call runs them
+	assert.True(t, runCleanups())
+	assert.Equal(t, []int{3, 2, 1}, order)
+}
+
+func TestHandleSignals(t *testing.T) {
+	defer func() {
+		signal.Reset()
+		atomic.StoreInt32(&interrupted, 0)
+	}()
+	handleSignals()
+
+	// the signal is recorded, the handlers are left for the main goroutine
+	assert.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&interrupted) != 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(143), atomic.LoadInt32(&interrupted))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&cleanups.running))
+
+	// the first signal sets the exit status
+	assert.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(143), atomic.LoadInt32(&interrupted))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+// compression methods for output streams
+const (
+	compressNone = "none"
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+// size of the blocks compressed in parallel by gzip
+const gzipBlockSize = 1 << 20
+
+// compressionFor - the compression method for an output path, when no method is
+// specified it is chosen by the file extension
+func compressionFor(path string, method string) string {
+	if method != "" {
+		return method
+	}
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return compressGzip
+	case strings.HasSuffix(path, ".zst"), strings.HasSuffix(path, ".zstd"):
+		return compressZstd
+	}
+	return compressNone
+}
+
+// compressionExt - the file extension for a compression method
+func compressionExt(method string) string {
+	switch method {
+	case compressGzip:
+		return ".gz"
+	case compressZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// outputStream - a buffered and optionally compressed output
+type outputStream struct {
+	*bufio.Writer
+	compressor io.WriteCloser // nil when uncompressed
+	file       *os.File       // nil for stdout
+}
+
+// openOutputStream - create the file at path ('-' for stdout) and compress
+// everything written to it using method
+func openOutputStream(path string, method string) *outputStream {
+	s := &outputStream{}
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			fatal(err)
+		}
+		s.file = file
+		w = file
+	}
+
+	switch method {
+	case compressGzip:
+		// compress blocks in parallel so compression doesn't hold up printing
+		gz := pgzip.NewWriter(w)
+		if err := gz.SetConcurrency(gzipBlockSize, 2*runtime.GOMAXPROCS(-1)); err != nil {
+			fatal(err)
+		}
+		s.compressor = gz
+	case compressZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			fatal(err)
+		}
+		s.compressor = zw
+	}
+
+	if s.compressor != nil {
+		w = s.compressor
+	}
+	s.Writer = bufio.NewWriter(w)
+	return s
+}
+
+// Close - flush all buffered data and close the file
+func (s *outputStream) Close() error {
+	err := s.Flush()
+	if s.compressor != nil {
+		if cerr := s.compressor.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if s.file != nil {
+		if cerr := s.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressionFor(t *testing.T) {
+	assert.Equal(t, compressNone, compressionFor("/tmp/out.json", ""))
+	assert.Equal(t, compressGzip, compressionFor("/tmp/out.json.gz", ""))
+	assert.Equal(t, compressZstd, compressionFor("/tmp/out.json.zst", ""))
+	assert.Equal(t, compressZstd, compressionFor("/tmp/out.json.zstd", ""))
+	assert.Equal(t, compressNone, compressionFor("-", ""))
+
+	// an explicit method takes precedence
+	assert.Equal(t, compressZstd, compressionFor("/tmp/out.json.gz", compressZstd))
+	assert.Equal(t, compressGzip, compressionFor("-", compressGzip))
+}
+
+func TestCompressionExt(t *testing.T) {
+	assert.Equal(t, "", compressionExt(compressNone))
+	assert.Equal(t, ".gz", compressionExt(compressGzip))
+	assert.Equal(t, ".zst", compressionExt(compressZstd))
+}
+
+// write enough records to span several gzip blocks
+func writeRecords(t *testing.T, path string, method string) string {
+	var expected strings.Builder
+	stream := openOutputStream(path, method)
+	for i := 0; i < 50000; i++ {
+		line := `{"id":1,"type":"node","tags":{"name":"Oriental Bay Fountain"}}` + "\n"
+		stream.WriteString(line)
+		expected.WriteString(line)
+	}
+	assert.Nil(t, stream.Close())
+	return expected.String()
+}
+
+func TestOutputStreamGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.json.gz")
+	expected := writeRecords(t, path, compressGzip)
+
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(data))
+}
+
+func TestOutputStreamZstd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.json.zst")
+	expected := writeRecords(t, path, compressZstd)
+
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+	reader, err := zstd.NewReader(file)
+	assert.Nil(t, err)
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(data))
+}
+
+func TestOutputStreamNone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.json")
+	expected := writeRecords(t, path, compressNone)
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(data))
+}
+
+func TestOutputStreamCloseError(t *testing.T) {
+	stream := openOutputStream(filepath.Join(t.TempDir(), "out.json.gz"), compressGzip)
+	stream.WriteString("{}\n")
+
+	// buffered data can't be written once the file is closed
+	stream.file.Close()
+	assert.NotNil(t, stream.Close())
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// element types which a layer may match
+var elementTypes = []string{"node", "way", "relation"}
+
+// fileConfig - the contents of a -config file
+type fileConfig struct {
+	Flags    map[string]interface{} `json:"flags"`    // default values for command line flags
+	Layers   []layerConfig          `json:"layers"`   // named layers, in order of precedence
+	Profiles []profileConfig        `json:"profiles"` // independent sets of layers
+}
+
+// profileConfig - the definition of a profile
+type profileConfig struct {
+	Name   string        `json:"name"`
+	Output string        `json:"output"` // path to write records to, default stdout
+	Layers []layerConfig `json:"layers"`
+}
+
+// layerConfig - the definition of a named layer
+type layerConfig struct {
+	Name     string   `json:"name"`
+	Tags     []string `json:"tags"`      // groups of tag conditions, as -tags
+	Types    []string `json:"types"`     // element types to match, default all
+	KeepTags []string `json:"keep_tags"` // glob patterns, as -keep-tags
+	DropTags []string `json:"drop_tags"` // glob patterns, as -drop-tags
+	Output   string   `json:"output"`    // path to write records to, default stdout
+}
+
+// layer - a set of tag conditions and where to print the records matching them
+type layer struct {
+	Name    string
+	Tags    map[string][]string
+	Types   map[string]bool
+	Project *tagProjection
+	Output  string
+	out     recordOutput
+}
+
+// profile - an independent set of layers, an element is printed once for every
+// profile it matches, by the first of the profile's layers which it matches.
+type profile struct {
+	Name   string
+	Layers []*layer
+}
+
+// loadConfig - read a JSON config file
+func loadConfig(path string) (*fileConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &fileConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// applyFlags - set flags from the config file, unless they were set on the command line
+func (c *fileConfig) applyFlags(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for name, value := range c.Flags {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("invalid config, unknown flag '%s'", name)
+		}
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, flagValue(value)); err != nil {
+			return fmt.Errorf("invalid config, flag '%s': %v", name, err)
+		}
+	}
+	return nil
+}
+
+// render a JSON value as a flag value, lists are joined with commas
+func flagValue(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		var parts []string
+		for _, part := range v {
+			parts = append(parts, flagValue(part))
+		}
+		return strings.Join(parts, ",")
+	case float64:
+		// note: integers are printed without an exponent, fractions are kept
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// newProfiles - validate the profiles, the top-level layers form a single unnamed profile
+func (c *fileConfig) newProfiles() ([]*profile, error) {
+	configs := c.Profiles
+	if len(c.Layers) > 0 {
+		configs = append([]profileConfig{{Layers: c.Layers}}, configs...)
+	}
+
+	var profiles []*profile
+	names := make(map[string]bool)
+	outputs := make(map[string]string)
+	for _, pc := range configs {
+		if names[pc.Name] {
+			return nil, fmt.Errorf("invalid config, duplicate profile name '%s'", pc.Name)
+		}
+		names[pc.Name] = true
+
+		// each profile is routed to its own output
+		output := pc.Output
+		if output == "" {
+			output = "-"
+		}
+		if other, ok := outputs[output]; ok {
+			return nil, fmt.Errorf("invalid config, profiles '%s' and '%s' share the output '%s'", other, pc.Name, output)
+		}
+		outputs[output] = pc.Name
+
+		p := &profile{Name: pc.Name}
+		layerNames := make(map[string]bool)
+		for _, lc := range pc.Layers {
+			if layerNames[lc.Name] {
+				return nil, fmt.Errorf("invalid config, duplicate layer name '%s'", lc.Name)
+			}
+			layerNames[lc.Name] = true
+
+			if lc.Output == "" {
+				lc.Output = pc.Output
+			}
+			l, err := newLayer(lc)
+			if err != nil {
+				return nil, err
+			}
+			p.Layers = append(p.Layers, l)
+		}
+		if len(p.Layers) == 0 {
+			return nil, fmt.Errorf("invalid config, profile '%s' has no layers", pc.Name)
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// newLayer - validate a layer definition
+func newLayer(c layerConfig) (*layer, error) {
+	if len(c.Tags) == 0 {
+		return nil, fmt.Errorf("layer '%s' has no tags to match against", c.Name)
+	}
+
+	l := &layer{
+		Name:   c.Name,
+		Tags:   parseTagConditions(strings.Join(c.Tags, ",")),
+		Types:  make(map[string]bool),
+		Output: c.Output,
+	}
+
+	types := c.Types
+	if len(types) == 0 {
+		types = elementTypes
+	}
+	for _, kind := range types {
+		if !containsString(elementTypes, kind) {
+			return nil, fmt.Errorf("layer '%s' has an unknown type '%s'", c.Name, kind)
+		}
+		l.Types[kind] = true
+	}
+
+	project, err := newTagProjection(strings.Join(c.KeepTags, ","), strings.Join(c.DropTags, ","))
+	if err != nil {
+		return nil, fmt.Errorf("layer '%s': %v", c.Name, err)
+	}
+	l.Project = project
+
+	return l, nil
+}
+
+// parse tag conditions, groups are separated with commas and
+// the conditions within a group with a +
+func parseTagConditions(list string) map[string][]string {
+	conditions := make(map[string][]string)
+	for _, group := range strings.Split(list, ",") {
+		conditions[group] = strings.Split(group, "+")
+	}
+	return conditions
+}
+
+// matchLayer - find the first layer matching an element, tags must already be normalised
+func matchLayer(layers []*layer, kind string, tags map[string]string) *layer {
+	for _, l := range layers {
+		if l.Types[kind] && containsValidTags(tags, l.Tags) {
+			return l
+		}
+	}
+	return nil
+}
+
+// matchProfiles - find the layer matching an element in each profile
+func matchProfiles(profiles []*profile, kind string, tags map[string]string) []*layer {
+	var layers []*layer
+	for _, p := range profiles {
+		if l := matchLayer(p.Layers, kind, tags); l != nil {
+			layers = append(layers, l)
+		}
+	}
+	return layers
+}
+
+// openLayerOutputs - open the output of each layer, layers with the same
+// output share a writer. Layers without an output write to the output
+// directory, if any, otherwise to path ('-' for stdout). Outputs are buffered,
+// compressed using method or by file extension, and are flushed and closed on
+// exit. The files opened are returned, excluding the output directory.
+func openLayerOutputs(profiles []*profile, dir *outputDir, path string, method string, format recordFormat) []*outputFile {
+	var files []*outputFile
+	writers := make(map[string]recordWriter)
+	for _, p := range profiles {
+		for _, l := range p.Layers {
+			output := l.Output
+			if output == "" && dir != nil {
+				l.out = &encodedOutput{format, dir.Writer(p.Name, l.Name)}
+				continue
+			}
+			if output == "" {
+				output = path
+			}
+			if w, ok := writers[output]; ok {
+				l.out = &encodedOutput{format, w}
+				continue
+			}
+			if output == "-" {
+				stream := openOutputStream(output, compressionFor(output, method))
+				addCleanup(stream.Close)
+				if header := format.Header(); header != nil {
+					writeHeader(stream, header)
+				}
+				writers[output] = &streamWriter{stream}
+			} else {
+				f := openOutputFile(output, compressionFor(output, method), format.Header())
+				addCleanup(f.close)
+				files = append(files, f)
+				writers[output] = &fileWriter{f}
+			}
+			l.out = &encodedOutput{format, writers[output]}
+		}
+	}
+	return files
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"flags":{"waynodes":true},"layers":[{"name":"venues","tags":["amenity","shop"]}]}`
+	assert.Nil(t, ioutil.WriteFile(path, []byte(data), 0644))
+
+	config, err := loadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"waynodes": true}, config.Flags)
+	assert.Equal(t, []layerConfig{{Name: "venues", Tags: []string{"amenity", "shop"}}}, config.Layers)
+
+	// invalid json
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"layers":`), 0644))
+	_, err = loadConfig(path)
+	assert.NotNil(t, err)
+}
+
+func TestApplyFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	batch := fs.Int("batch", 50000, "")
+	wayNodes := fs.Bool("waynodes", false, "")
+	metadata := fs.String("metadata", "", "")
+	simplify := fs.Float64("simplify", 0, "")
+	rotate := fs.Int64("rotate-bytes", 0, "")
+	assert.Nil(t, fs.Parse([]string{"-batch=10"}))
+
+	config := &fileConfig{Flags: map[string]interface{}{
+		"batch":        float64(20),
+		"waynodes":     true,
+		"metadata":     []interface{}{"version", "user"},
+		"simplify":     0.5,
+		"rotate-bytes": float64(5e9),
+	}}
+	assert.Nil(t, config.applyFlags(fs))
+
+	// flags on the command line take precedence
+	assert.Equal(t, 10, *batch)
+	assert.True(t, *wayNodes)
+	assert.Equal(t, "version,user", *metadata)
+	assert.Equal(t, 0.5, *simplify)
+	assert.Equal(t, int64(5e9), *rotate)
+
+	// fractions are not truncated for integer flags
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("batch", 50000, "")
+	config = &fileConfig{Flags: map[string]interface{}{"batch": 1.5}}
+	assert.NotNil(t, config.applyFlags(fs))
+
+	// unknown flags
+	config = &fileConfig{Flags: map[string]interface{}{"colour": "red"}}
+	assert.NotNil(t, config.applyFlags(fs))
+
+	// invalid values
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("waynodes", false, "")
+	config = &fileConfig{Flags: map[string]interface{}{"waynodes": "maybe"}}
+	assert.NotNil(t, config.applyFlags(fs))
+}
+
+func TestNewLayer(t *testing.T) {
+	l, err := newLayer(layerConfig{Name: "venues", Tags: []string{"amenity", "shop+name"}, Types: []string{"node", "way"}, KeepTags: []string{"name"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "venues", l.Name)
+	assert.Equal(t, map[string][]string{"amenity": {"amenity"}, "shop+name": {"shop", "name"}}, l.Tags)
+	assert.Equal(t, map[string]bool{"node": true, "way": true}, l.Types)
+	assert.Equal(t, []string{"name"}, l.Project.keep)
+
+	// all types by default
+	l, err = newLayer(layerConfig{Name: "all", Tags: []string{"amenity"}})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"node": true, "way": true, "relation": true}, l.Types)
+	assert.Nil(t, l.Project)
+
+	// invalid layers
+	_, err = newLayer(layerConfig{Name: "empty"})
+	assert.NotNil(t, err)
+	_, err = newLayer(layerConfig{Name: "type", Tags: []string{"amenity"}, Types: []string{"area"}})
+	assert.NotNil(t, err)
+	_, err = newLayer(layerConfig{Name: "pattern", Tags: []string{"amenity"}, DropTags: []string{"["}})
+	assert.NotNil(t, err)
+}
+
+func TestMatchLayer(t *testing.T) {
+	venues, _ := newLayer(layerConfig{Name: "venues", Tags: []string{"amenity"}, Types: []string{"node"}})
+	named, _ := newLayer(layerConfig{Name: "named", Tags: []string{"name"}})
+	layers := []*layer{venues, named}
+
+	// the first matching layer wins
+	tags := map[string]string{"amenity": "cafe", "name": "A"}
+	assert.Equal(t, venues, matchLayer(layers, "node", tags))
+	assert.Equal(t, named, matchLayer(layers, "way", tags))
+	assert.Nil(t, matchLayer(layers, "way", map[string]string{"amenity": "cafe"}))
+}
+
+func TestNewProfiles(t *testing.T) {
+	config := &fileConfig{
+		Layers: []layerConfig{{Name: "default", Tags: []string{"amenity"}}},
+		Profiles: []profileConfig{
+			{Name: "addresses", Output: "/tmp/addresses.json", Layers: []layerConfig{
+				{Name: "address", Tags: []string{"addr:housenumber+addr:street"}},
+			}},
+			{Name: "streets", Output: "/tmp/streets.json", Layers: []layerConfig{
+				{Name: "street", Tags: []string{"highway+name"}, Types: []string{"way"}},
+				{Name: "other", Tags: []string{"highway"}, Output: "/tmp/other.json"},
+			}},
+		},
+	}
+	profiles, err := config.newProfiles()
+	assert.Nil(t, err)
+	assert.Len(t, profiles, 3)
+
+	// top-level layers form an unnamed profile
+	assert.Equal(t, "", profiles[0].Name)
+	assert.Equal(t, "default", profiles[0].Layers[0].Name)
+	assert.Equal(t, "", profiles[0].Layers[0].Output)
+
+	// layers write to the profile output unless they specify their own
+	assert.Equal(t, "/tmp/addresses.json", profiles[1].Layers[0].Output)
+	assert.Equal(t, "/tmp/streets.json", profiles[2].Layers[0].Output)
+	assert.Equal(t, "/tmp/other.json", profiles[2].Layers[1].Output)
+
+	// profiles must not share an output
+	config.Profiles[1].Output = ""
+	_, err = config.newProfiles()
+	assert.NotNil(t, err)
+
+	// duplicate names
+	config = &fileConfig{Profiles: []profileConfig{
+		{Name: "a", Output: "a.json", Layers: []layerConfig{{Name: "x", Tags: []string{"name"}}}},
+		{Name: "a", Output: "b.json", Layers: []layerConfig{{Name: "x", Tags: []string{"name"}}}},
+	}}
+	_, err = config.newProfiles()
+	assert.NotNil(t, err)
+
+	// no layers
+	config = &fileConfig{Profiles: []profileConfig{{Name: "a"}}}
+	_, err = config.newProfiles()
+	assert.NotNil(t, err)
+}
+
+func TestMatchProfiles(t *testing.T) {
+	venues, _ := newLayer(layerConfig{Name: "venues", Tags: []string{"amenity"}})
+	named, _ := newLayer(layerConfig{Name: "named", Tags: []string{"name"}})
+	streets, _ := newLayer(layerConfig{Name: "streets", Tags: []string{"highway"}})
+	profiles := []*profile{{Layers: []*layer{venues, named}}, {Layers: []*layer{streets, named}}}
+
+	tags := map[string]string{"amenity": "cafe", "name": "A"}
+	assert.Equal(t, []*layer{venues, named}, matchProfiles(profiles, "node", tags))
+	assert.Empty(t, matchProfiles(profiles, "node", map[string]string{"building": "yes"}))
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/go.geo"
+)
+
+// the columns printed when no -columns are specified
+const defaultColumns = "id,type,lat,lon"
+
+// element fields which can be selected as columns, any other column is a tag
+var csvFields = map[string]bool{
+	"id": true, "type": true, "layer": true, "lat": true, "lon": true,
+	"centroid.lat": true, "centroid.lon": true, "centroid.type": true,
+	"bounds.n": true, "bounds.s": true, "bounds.e": true, "bounds.w": true,
+	"partial": true, "version": true, "timestamp": true, "changeset": true,
+	"uid": true, "user": true, "visible": true,
+}
+
+// csvColumn - a column of csv/tsv output, either an element field or a tag
+type csvColumn struct {
+	name  string // as listed in -columns, used in the header row
+	field string
+	tag   string
+}
+
+// parseColumns - parse a comma-separated list of columns, the 'tags.' prefix
+// selects a tag which has the same name as an element field eg. 'tags.type'
+func parseColumns(list string) ([]csvColumn, error) {
+	if len(list) < 1 {
+		list = defaultColumns
+	}
+	var columns []csvColumn
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "" || name == "tags.":
+			return nil, fmt.Errorf("invalid -columns, empty column name in: %s", list)
+		case strings.HasPrefix(name, "tags."):
+			columns = append(columns, csvColumn{name: name, tag: strings.TrimPrefix(name, "tags.")})
+		case csvFields[name]:
+			columns = append(columns, csvColumn{name: name, field: name})
+		default:
+			columns = append(columns, csvColumn{name: name, tag: name})
+		}
+	}
+	return columns, nil
+}
+
+// csvEncoder - encodes the selected columns of records as a csv or tsv row.
+// csv values are quoted as in RFC 4180, tsv values have tabs, newlines and
+// backslashes escaped with a backslash.
+type csvEncoder struct {
+	tsv     bool
+	columns []csvColumn
+	json    *recordEncoder // encodes the coordinates
+	value   []byte
+}
+
+// newCSVEncoder - constructor, format is either csv or tsv and coordinates
+// are rounded to precision decimal places
+func newCSVEncoder(format string, columns []csvColumn, precision int) *csvEncoder {
+	return &csvEncoder{tsv: format == formatTSV, columns: columns, json: newRecordEncoder(schemaV2, precision)}
+}
+
+func (e *csvEncoder) Ext() string {
+	if e.tsv {
+		return ".tsv"
+	}
+	return ".csv"
+}
+
+// Header - the column names
+func (e *csvEncoder) Header() []byte {
+	var b []byte
+	for i, c := range e.columns {
+		b = e.appendValue(b, i, []byte(c.name))
+	}
+	return b
+}
+
+// csvRecord - the fields of a node, way or relation
+type csvRecord struct {
+	id       int64
+	kind     string
+	layer    string
+	tags     interface{}
+	centroid jsonCentroid
+	bounds   *geo.Bound // nil for nodes
+	partial  bool
+	meta     *jsonMetadata
+}
+
+// Node - encode a node row, nodes have no bounds
+func (e *csvEncoder) Node(r *jsonNode) []byte {
+	return e.row(&csvRecord{r.ID, r.Type, r.Layer, r.Tags, jsonCentroid{Lat: r.Lat, Lon: r.Lon}, nil, false, r.jsonMetadata})
+}
+
+// Way - encode a way row
+func (e *csvEncoder) Way(r *jsonWay) []byte {
+	return e.row(&csvRecord{r.ID, r.Type, r.Layer, r.Tags, r.Centroid, r.Bounds, r.Partial, r.jsonMetadata})
+}
+
+// Relation - encode a relation row
+func (e *csvEncoder) Relation(r *jsonRelation) []byte {
+	return e.row(&csvRecord{r.ID, r.Type, r.Layer, r.Tags, r.Centroid, r.Bounds, r.Partial, r.jsonMetadata})
+}
+
+func (e *csvEncoder) row(r *csvRecord) []byte {
+	b := e.json.buf[:0]
+	for i, c := range e.columns {
+		e.value = e.value[:0]
+		if c.field != "" {
+			e.value = e.appendField(e.value, c.field, r)
+		} else {
+			e.value = appendTagValue(e.value, r.tags, c.tag)
+		}
+		b = e.appendValue(b, i, e.value)
+	}
+
+	// an empty line is skipped by csv readers, so a single empty value is quoted
+	if !e.tsv && len(b) == 0 {
+		b = append(b, `""`...)
+	}
+	e.json.buf = b
+	return b
+}
+
+// append the value of an element field, empty if the record doesn't have it
+func (e *csvEncoder) appendField(b []byte, field string, r *csvRecord) []byte {
+	switch field {
+	case "id":
+		return strconv.AppendInt(b, r.id, 10)
+	case "type":
+		return append(b, r.kind...)
+	case "layer":
+		return append(b, r.layer...)
+	case "lat", "centroid.lat":
+		return e.json.appendCoord(b, r.centroid.Lat)
+	case "lon", "centroid.lon":
+		return e.json.appendCoord(b, r.centroid.Lon)
+	case "centroid.type":
+		return append(b, r.centroid.Type...)
+	case "partial":
+		return strconv.AppendBool(b, r.partial)
+	}
+
+	if r.bounds != nil {
+		switch field {
+		case "bounds.n":
+			return e.json.appendCoord(b, r.bounds.North())
+		case "bounds.s":
+			return e.json.appendCoord(b, r.bounds.South())
+		case "bounds.e":
+			return e.json.appendCoord(b, r.bounds.East())
+		case "bounds.w":
+			return e.json.appendCoord(b, r.bounds.West())
+		}
+	}
+
+	if meta := r.meta; meta != nil {
+		switch {
+		case field == "version" && meta.Version != nil:
+			return strconv.AppendInt(b, int64(*meta.Version), 10)
+		case field == "timestamp" && meta.Timestamp != nil:
+			return meta.Timestamp.AppendFormat(b, time.RFC3339Nano)
+		case field == "changeset" && meta.Changeset != nil:
+			return strconv.AppendInt(b, *meta.Changeset, 10)
+		case field == "uid" && meta.UID != nil:
+			return strconv.AppendInt(b, int64(*meta.UID), 10)
+		case field == "user" && meta.User != nil:
+			return append(b, *meta.User...)
+		case field == "visible" && meta.Visible != nil:
+			return strconv.AppendBool(b, *meta.Visible)
+		}
+	}
+	return b
+}
+
+// append the value of a tag, split values are joined with ';' as in OSM
+func appendTagValue(b []byte, tags interface{}, key string) []byte {
+	switch tags := tags.(type) {
+	case map[string]string:
+		return append(b, tags[key]...)
+	case map[string]interface{}:
+		switch v := tags[key].(type) {
+		case string:
+			return append(b, v...)
+		case []string:
+			return append(b, strings.Join(v, ";")...)
+		}
+	}
+	return b
+}
+
+// append the i'th value of a row, quoted or escaped as required
+func (e *csvEncoder) appendValue(b []byte, i int, value []byte) []byte {
+	if e.tsv {
+		if i > 0 {
+			b = append(b, '\t')
+		}
+		return appendTSVValue(b, value)
+	}
+	if i > 0 {
+		b = append(b, ',')
+	}
+	return appendCSVValue(b, value)
+}
+
+// append a csv value, values containing a comma, quote or line break (or
+// leading space) are quoted and quotes are doubled
+func appendCSVValue(b []byte, value []byte) []byte {
+	quote := len(value) > 0 && (value[0] == ' ' || value[0] == '\t')
+	for _, c := range value {
+		if c == ',' || c == '"' || c == '\n' || c == '\r' {
+			quote = true
+			break
+		}
+	}
+	if !quote {
+		return append(b, value...)
+	}
+	b = append(b, '"')
+	for _, c := range value {
+		if c == '"' {
+			b = append(b, '"')
+		}
+		b = append(b, c)
+	}
+	return append(b, '"')
+}
+
+// append a tsv value, tabs, line breaks and backslashes are escaped
+func appendTSVValue(b []byte, value []byte) []byte {
+	for _, c := range value {
+		switch c {
+		case '\t':
+			b = append(b, '\\', 't')
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\\':
+			b = append(b, '\\', '\\')
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/paulmach/go.geo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseColumns(t *testing.T) {
+	columns, err := parseColumns("id, centroid.lat,addr:street,tags.type")
+	assert.Nil(t, err)
+	assert.Equal(t, []csvColumn{
+		{name: "id", field: "id"},
+		{name: "centroid.lat", field: "centroid.lat"},
+		{name: "addr:street", tag: "addr:street"},
+		{name: "tags.type", tag: "type"},
+	}, columns)
+
+	columns, err = parseColumns("")
+	assert.Nil(t, err)
+	assert.Len(t, columns, 4)
+
+	_, err = parseColumns("id,,name")
+	assert.NotNil(t, err)
+	_, err = parseColumns("tags.")
+	assert.NotNil(t, err)
+}
+
+func TestCSVEncoder(t *testing.T) {
+	columns, _ := parseColumns("id,type,lat,lon,name,addr:street,bounds.n,partial,tags.type")
+	e := newCSVEncoder(formatCSV, columns, 3)
+	assert.Equal(t, ".csv", e.Ext())
+	assert.Equal(t, "id,type,lat,lon,name,addr:street,bounds.n,partial,tags.type", string(e.Header()))
+
+	node := jsonNode{ID: 1, Type: "node", Lat: 1.23456, Lon: -2, Tags: map[string]string{"name": `Joe's "Cafe", Bar`}}
+	assert.Equal(t, `1,node,1.235,-2,"Joe's ""Cafe"", Bar",,,false,`, string(e.Node(&node)))
+
+	way := jsonWay{ID: 10, Type: "way", Tags: map[string]string{"name": "line\nbreak", "addr:street": " Main St"}, Centroid: jsonCentroid{Lat: 2, Lon: 3}, Bounds: geo.NewBound(1, 4, 1, 4)}
+	assert.Equal(t, "10,way,2,3,\"line\nbreak\",\" Main St\",4,false,", string(e.Way(&way)))
+
+	relation := jsonRelation{ID: 20, Type: "relation", Tags: map[string]interface{}{"type": "multipolygon", "name": []string{"a", "b"}}, Bounds: geo.NewBound(1, 2, 3, 4), Partial: true}
+	assert.Equal(t, "20,relation,0,0,a;b,,4,true,multipolygon", string(e.Relation(&relation)))
+
+	// the rows are valid csv
+	var buf bytes.Buffer
+	w := &streamWriter{&buf}
+	writeHeader(&buf, e.Header())
+	w.WriteRecord("node", node.ID, e.Node(&node))
+	w.WriteRecord("way", way.ID, e.Way(&way))
+	w.WriteRecord("relation", relation.ID, e.Relation(&relation))
+	records, err := csv.NewReader(&buf).ReadAll()
+	assert.Nil(t, err)
+	assert.Len(t, records, 4)
+	assert.Equal(t, `Joe's "Cafe", Bar`, records[1][4])
+	assert.Equal(t, " Main St", records[2][5])
+}
+
+func TestCSVEncoderSingleColumn(t *testing.T) {
+	columns, _ := parseColumns("name")
+	e := newCSVEncoder(formatCSV, columns, 7)
+	assert.Equal(t, `""`, string(e.Node(&jsonNode{Tags: map[string]string{}})))
+}
+
+func TestTSVEncoder(t *testing.T) {
+	columns, _ := parseColumns("id,name,user")
+	e := newCSVEncoder(formatTSV, columns, 7)
+	assert.Equal(t, ".tsv", e.Ext())
+	assert.Equal(t, "id\tname\tuser", string(e.Header()))
+
+	user := "map\\per"
+	node := jsonNode{ID: 1, Type: "node", Tags: map[string]string{"name": "tab\there\nnow"}, jsonMetadata: &jsonMetadata{User: &user}}
+	assert.Equal(t, "1\ttab\\there\\nnow\tmap\\\\per", string(e.Node(&node)))
+}
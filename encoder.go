@@ -0,0 +1,429 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/paulmach/go.geo"
+)
+
+// output schema versions
+const (
+	schemaV1 = 1 // coordinates of centroids, bounds and way nodes are strings
+	schemaV2 = 2 // all coordinates are numbers of a configurable precision
+)
+
+// coordinates are printed with 7 decimal places by default (~1cm)
+const defaultPrecision = 7
+
+// recordEncoder - encodes records as JSON. The schema v1 output is identical
+// to encoding/json but the buffer is reused between records so encoding
+// allocates very little.
+// note: the buffer is overwritten by the next record, writers must not
+// retain it.
+type recordEncoder struct {
+	schema    int
+	precision int    // decimal places of schema v2 coordinates
+	geometry  string // the format of way and relation geometries
+	buf       []byte
+	keys      []string // scratch space for sorting map keys
+	geom      []byte
+}
+
+// newRecordEncoder - constructor
+func newRecordEncoder(schema int, precision int) *recordEncoder {
+	return &recordEncoder{schema: schema, precision: precision}
+}
+
+func (e *recordEncoder) Ext() string {
+	return ".json"
+}
+
+func (e *recordEncoder) Header() []byte {
+	return nil
+}
+
+// Node - encode a node record
+func (e *recordEncoder) Node(r *jsonNode) []byte {
+	b := e.buf[:0]
+	b = append(b, `{"id":`...)
+	b = strconv.AppendInt(b, r.ID, 10)
+	b = append(b, `,"type":"node"`...)
+	b = appendLayer(b, r.Layer)
+	b = append(b, `,"lat":`...)
+	b = e.appendNumber(b, r.Lat)
+	b = append(b, `,"lon":`...)
+	b = e.appendNumber(b, r.Lon)
+	b = append(b, `,"tags":`...)
+	b = e.appendTags(b, r.Tags)
+	b = appendMetadata(b, r.jsonMetadata)
+	b = append(b, '}')
+	e.buf = b
+	return b
+}
+
+// Way - encode a way record
+func (e *recordEncoder) Way(r *jsonWay) []byte {
+	b := e.buf[:0]
+	b = append(b, `{"id":`...)
+	b = strconv.AppendInt(b, r.ID, 10)
+	b = append(b, `,"type":"way"`...)
+	b = appendLayer(b, r.Layer)
+	b = append(b, `,"tags":`...)
+	b = e.appendTags(b, r.Tags)
+	b = append(b, `,"centroid":`...)
+	b = e.appendCentroid(b, r.Centroid)
+	b = append(b, `,"bounds":`...)
+	b = e.appendBounds(b, r.Bounds)
+	if r.Geometry != nil {
+		b = append(b, `,"geometry":`...)
+		b = e.appendGeometry(b, r.Geometry)
+	}
+	if len(r.Nodes) > 0 {
+		b = append(b, `,"nodes":[`...)
+		for i, latlon := range r.Nodes {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = e.appendLatLon(b, latlon)
+		}
+		b = append(b, ']')
+	}
+	if r.Partial {
+		b = append(b, `,"partial":true`...)
+	}
+	b = appendMetadata(b, r.jsonMetadata)
+	b = append(b, '}')
+	e.buf = b
+	return b
+}
+
+// Relation - encode a relation record
+func (e *recordEncoder) Relation(r *jsonRelation) []byte {
+	b := e.buf[:0]
+	b = append(b, `{"id":`...)
+	b = strconv.AppendInt(b, r.ID, 10)
+	b = append(b, `,"type":"relation"`...)
+	b = appendLayer(b, r.Layer)
+	b = append(b, `,"tags":`...)
+	b = e.appendTags(b, r.Tags)
+	b = append(b, `,"centroid":`...)
+	b = e.appendCentroid(b, r.Centroid)
+	b = append(b, `,"bounds":`...)
+	b = e.appendBounds(b, r.Bounds)
+	if r.Geometry != nil {
+		b = append(b, `,"geometry":`...)
+		b = e.appendGeometry(b, r.Geometry)
+	}
+	if r.Partial {
+		b = append(b, `,"partial":true`...)
+	}
+	b = appendMetadata(b, r.jsonMetadata)
+	b = append(b, '}')
+	e.buf = b
+	return b
+}
+
+func appendLayer(b []byte, layer string) []byte {
+	if layer == "" {
+		return b
+	}
+	b = append(b, `,"layer":`...)
+	return appendString(b, layer)
+}
+
+// append tags in key order, values are strings or, once split, arrays of strings
+func (e *recordEncoder) appendTags(b []byte, tags interface{}) []byte {
+	switch tags := tags.(type) {
+	case map[string]string:
+		if tags == nil {
+			return append(b, "null"...)
+		}
+		keys := e.keys[:0]
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.keys = keys
+		b = append(b, '{')
+		for i, k := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendString(b, k)
+			b = append(b, ':')
+			b = appendString(b, tags[k])
+		}
+		return append(b, '}')
+	case map[string]interface{}:
+		if tags == nil {
+			return append(b, "null"...)
+		}
+		keys := e.keys[:0]
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.keys = keys
+		b = append(b, '{')
+		for i, k := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendString(b, k)
+			b = append(b, ':')
+			b = appendStringValue(b, tags[k])
+		}
+		return append(b, '}')
+	}
+	return append(b, "null"...)
+}
+
+// append a tag value, either a string or an array of strings
+func appendStringValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return appendString(b, v)
+	case []string:
+		if v == nil {
+			return append(b, "null"...)
+		}
+		b = append(b, '[')
+		for i, s := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendString(b, s)
+		}
+		return append(b, ']')
+	}
+	return append(b, "null"...)
+}
+
+// render a centroid, v1 coordinates are strings with 7 decimal places
+func (e *recordEncoder) appendCentroid(b []byte, c jsonCentroid) []byte {
+	b = append(b, `{"lat":`...)
+	b = e.appendCoord(b, c.Lat)
+	b = append(b, `,"lon":`...)
+	b = e.appendCoord(b, c.Lon)
+	if c.Type != "" {
+		b = append(b, `,"type":`...)
+		b = appendString(b, c.Type)
+	}
+	return append(b, '}')
+}
+
+// render a bounding box, in v1 an object of North-South-East-West strings
+// and in v2 an array of [west, south, east, north] as in GeoJSON
+func (e *recordEncoder) appendBounds(b []byte, bounds *geo.Bound) []byte {
+	if bounds == nil {
+		return append(b, "null"...)
+	}
+	if e.schema == schemaV2 {
+		b = append(b, '[')
+		b = e.appendCoord(b, bounds.West())
+		b = append(b, ',')
+		b = e.appendCoord(b, bounds.South())
+		b = append(b, ',')
+		b = e.appendCoord(b, bounds.East())
+		b = append(b, ',')
+		b = e.appendCoord(b, bounds.North())
+		return append(b, ']')
+	}
+	b = append(b, `{"e":`...)
+	b = e.appendCoord(b, bounds.East())
+	b = append(b, `,"n":`...)
+	b = e.appendCoord(b, bounds.North())
+	b = append(b, `,"s":`...)
+	b = e.appendCoord(b, bounds.South())
+	b = append(b, `,"w":`...)
+	b = e.appendCoord(b, bounds.West())
+	return append(b, '}')
+}
+
+// render a way node, entrances include their bitmask values, as digits
+// in v1 and named values in v2
+func (e *recordEncoder) appendLatLon(b []byte, latlon latLon) []byte {
+	if e.schema == schemaV2 {
+		b = append(b, `{"lat":`...)
+		b = e.appendCoord(b, latlon.Lat)
+		b = append(b, `,"lon":`...)
+		b = e.appendCoord(b, latlon.Lon)
+		if latlon.Entrance > 0 {
+			b = append(b, `,"entrance":"`...)
+			b = append(b, entranceNames[latlon.Entrance&3]...)
+			b = append(b, `","wheelchair":"`...)
+			b = append(b, wheelchairNames[latlon.Wheelchair&3]...)
+			b = append(b, '"')
+		}
+		return append(b, '}')
+	}
+	b = append(b, '{')
+	if latlon.Entrance > 0 {
+		b = append(b, `"entrance":"`...)
+		b = strconv.AppendUint(b, uint64(latlon.Entrance), 10)
+		b = append(b, `",`...)
+	}
+	b = append(b, `"lat":`...)
+	b = e.appendCoord(b, latlon.Lat)
+	b = append(b, `,"lon":`...)
+	b = e.appendCoord(b, latlon.Lon)
+	if latlon.Entrance > 0 {
+		b = append(b, `,"wheelchair":"`...)
+		b = strconv.AppendUint(b, uint64(latlon.Wheelchair), 10)
+		b = append(b, '"')
+	}
+	return append(b, '}')
+}
+
+// schema v2 names of the entrance and wheelchair bitmask values
+// note: wheelchair is 'no' both for entrances tagged wheelchair=no and
+// for those without a wheelchair tag
+var entranceNames = [4]string{"", "yes", "main", ""}
+var wheelchairNames = [4]string{"no", "limited", "yes", ""}
+
+// append the selected metadata fields, in the order they are declared
+func appendMetadata(b []byte, meta *jsonMetadata) []byte {
+	if meta == nil {
+		return b
+	}
+	if meta.Version != nil {
+		b = append(b, `,"version":`...)
+		b = strconv.AppendInt(b, int64(*meta.Version), 10)
+	}
+	if meta.Timestamp != nil {
+		b = append(b, `,"timestamp":"`...)
+		b = meta.Timestamp.AppendFormat(b, time.RFC3339Nano)
+		b = append(b, '"')
+	}
+	if meta.Changeset != nil {
+		b = append(b, `,"changeset":`...)
+		b = strconv.AppendInt(b, *meta.Changeset, 10)
+	}
+	if meta.UID != nil {
+		b = append(b, `,"uid":`...)
+		b = strconv.AppendInt(b, int64(*meta.UID), 10)
+	}
+	if meta.User != nil {
+		b = append(b, `,"user":`...)
+		b = appendString(b, *meta.User)
+	}
+	if meta.Visible != nil {
+		b = append(b, `,"visible":`...)
+		b = strconv.AppendBool(b, *meta.Visible)
+	}
+	return b
+}
+
+// append a computed coordinate, in v1 a string with 7 decimal places
+func (e *recordEncoder) appendCoord(b []byte, f float64) []byte {
+	if e.schema == schemaV2 {
+		return appendRounded(b, f, e.precision)
+	}
+	b = append(b, '"')
+	b = strconv.AppendFloat(b, f, 'f', 7, 64)
+	return append(b, '"')
+}
+
+// append a node coordinate, in v1 it is printed at full precision
+func (e *recordEncoder) appendNumber(b []byte, f float64) []byte {
+	if e.schema == schemaV2 {
+		return appendRounded(b, f, e.precision)
+	}
+	return appendFloat(b, f)
+}
+
+// append a number rounded to a number of decimal places, without
+// trailing zeros
+func appendRounded(b []byte, f float64, precision int) []byte {
+	start := len(b)
+	b = strconv.AppendFloat(b, f, 'f', precision, 64)
+	if precision > 0 {
+		for b[len(b)-1] == '0' {
+			b = b[:len(b)-1]
+		}
+		if b[len(b)-1] == '.' {
+			b = b[:len(b)-1]
+		}
+	}
+	// values which round to zero are printed without a sign
+	if string(b[start:]) == "-0" {
+		b = append(b[:start], '0')
+	}
+	return b
+}
+
+// append a number formatted the same as encoding/json
+func appendFloat(b []byte, f float64) []byte {
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+const hexDigits = "0123456789abcdef"
+
+// append a quoted string, escaped the same as encoding/json including
+// the HTML characters <, > and &
+func appendString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		// invalid UTF-8 is replaced with the unicode replacement character
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		// line and paragraph separators are invalid in javascript strings
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/paulmach/go.geo"
+	"github.com/qedus/osmpbf"
+	"github.com/stretchr/testify/assert"
+)
+
+// the map based records printed by encoding/json before the recordEncoder,
+// the encoder output must be identical
+type mapWay struct {
+	ID       int64               `json:"id"`
+	Type     string              `json:"type"`
+	Layer    string              `json:"layer,omitempty"`
+	Tags     interface{}         `json:"tags"`
+	Centroid map[string]string   `json:"centroid"`
+	Bounds   map[string]string   `json:"bounds"`
+	Nodes    []map[string]string `json:"nodes,omitempty"`
+	Partial  bool                `json:"partial,omitempty"`
+	*jsonMetadata
+}
+
+type mapNode struct {
+	ID    int64       `json:"id"`
+	Type  string      `json:"type"`
+	Layer string      `json:"layer,omitempty"`
+	Lat   float64     `json:"lat"`
+	Lon   float64     `json:"lon"`
+	Tags  interface{} `json:"tags"`
+	*jsonMetadata
+}
+
+func formatCoord(f float64) string {
+	return strconv.FormatFloat(f, 'f', 7, 64)
+}
+
+func toMapWay(r *jsonWay) mapWay {
+	centroid := map[string]string{"lat": formatCoord(r.Centroid.Lat), "lon": formatCoord(r.Centroid.Lon)}
+	if r.Centroid.Type != "" {
+		centroid["type"] = r.Centroid.Type
+	}
+	bounds := map[string]string{
+		"n": formatCoord(r.Bounds.North()),
+		"s": formatCoord(r.Bounds.South()),
+		"e": formatCoord(r.Bounds.East()),
+		"w": formatCoord(r.Bounds.West()),
+	}
+	var nodes []map[string]string
+	for _, latlon := range r.Nodes {
+		node := map[string]string{"lat": formatCoord(latlon.Lat), "lon": formatCoord(latlon.Lon)}
+		if latlon.Entrance > 0 {
+			node["entrance"] = fmt.Sprintf("%d", latlon.Entrance)
+			node["wheelchair"] = fmt.Sprintf("%d", latlon.Wheelchair)
+		}
+		nodes = append(nodes, node)
+	}
+	return mapWay{r.ID, r.Type, r.Layer, r.Tags, centroid, bounds, nodes, r.Partial, r.jsonMetadata}
+}
+
+func TestEncodeString(t *testing.T) {
+	e := &recordEncoder{}
+	for _, s := range []string{
+		"", "cafe", "café", "日本語", `"quoted"`, `back\slash`, "<b>&amp;</b>",
+		"tab\tnew\nline\rreturn", "\x00\x01\x1f\x7f", "\xff invalid \xc3", "line\u2028para\u2029",
+	} {
+		expected, _ := json.Marshal(s)
+		assert.Equal(t, string(expected), string(appendString(e.buf[:0], s)), s)
+	}
+}
+
+func TestEncodeFloat(t *testing.T) {
+	for _, f := range []float64{0, 1, -1, 45.5424694, -122.9356798, 1e-7, -3.0000000000000004e-7, 1e-6, 179.9999999, 1e21} {
+		expected, _ := json.Marshal(f)
+		assert.Equal(t, string(expected), string(appendFloat(nil, f)))
+	}
+}
+
+func TestEncodeNode(t *testing.T) {
+	e := &recordEncoder{}
+	version, user, visible := int32(2), "mapper", false
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 600, time.UTC)
+	meta := &jsonMetadata{Version: &version, Timestamp: &ts, User: &user, Visible: &visible}
+
+	for _, r := range []jsonNode{
+		{ID: 1, Type: "node", Lat: 1.5, Lon: -2.25, Tags: map[string]string{"b": "2", "a": "1"}},
+		{ID: 2, Type: "node", Layer: "venues", Lat: 1e-7, Tags: map[string]string{}, jsonMetadata: meta},
+		{ID: 3, Type: "node", Tags: map[string]interface{}{"cuisine": []string{"pizza", "kebab"}, "name": "A"}},
+		{ID: 4, Type: "node", Tags: map[string]interface{}{"cuisine": []string{}}},
+	} {
+		expected, err := json.Marshal(mapNode{r.ID, r.Type, r.Layer, r.Lat, r.Lon, r.Tags, r.jsonMetadata})
+		assert.Nil(t, err)
+		assert.Equal(t, string(expected), string(e.Node(&r)))
+	}
+}
+
+func TestEncodeWay(t *testing.T) {
+	e := &recordEncoder{}
+	uid := int32(7)
+	latlons := []latLon{{Lat: 1, Lon: 1}, {Lat: 1, Lon: 2}, {Lat: 2, Lon: 2, Entrance: 2}, {Lat: 1, Lon: 1}}
+	centroid, bounds := computeCentroidAndBounds(latlons)
+
+	for _, r := range []jsonWay{
+		{ID: 10, Type: "way", Tags: map[string]string{"building": "yes"}, Centroid: centroid, Bounds: bounds},
+		{ID: 11, Type: "way", Layer: "buildings", Tags: map[string]string{"name": "<x>"}, Centroid: centroid, Bounds: bounds, Nodes: latlons},
+		{ID: 12, Type: "way", Tags: map[string]string{}, Centroid: jsonCentroid{Lat: -0.5, Lon: 0.25}, Bounds: bounds, Partial: true, jsonMetadata: &jsonMetadata{UID: &uid}},
+	} {
+		expected, err := json.Marshal(toMapWay(&r))
+		assert.Nil(t, err)
+		assert.Equal(t, string(expected), string(e.Way(&r)))
+	}
+}
+
+func TestEncodeRelation(t *testing.T) {
+	e := &recordEncoder{}
+	r := jsonRelation{
+		ID:       20,
+		Type:     "relation",
+		Tags:     map[string]string{"boundary": "administrative"},
+		Centroid: jsonCentroid{Lat: 5, Lon: 5, Type: "admin_centre"},
+		Bounds:   geo.NewBound(1, 2, 3, 4),
+		Partial:  true,
+	}
+	assert.Equal(t, `{"id":20,"type":"relation","tags":{"boundary":"administrative"},`+
+		`"centroid":{"lat":"5.0000000","lon":"5.0000000","type":"admin_centre"},`+
+		`"bounds":{"e":"2.0000000","n":"4.0000000","s":"3.0000000","w":"1.0000000"},"partial":true}`, string(e.Relation(&r)))
+}
+
+func TestEncodeSchemaV2(t *testing.T) {
+	e := newRecordEncoder(schemaV2, 3)
+
+	node := jsonNode{ID: 1, Type: "node", Lat: 1.23456, Lon: -0.0001, Tags: map[string]string{}}
+	assert.Equal(t, `{"id":1,"type":"node","lat":1.235,"lon":0,"tags":{}}`, string(e.Node(&node)))
+
+	way := jsonWay{
+		ID:       10,
+		Type:     "way",
+		Tags:     map[string]string{"building": "yes"},
+		Centroid: jsonCentroid{Lat: 2, Lon: 2.5, Type: "entrance"},
+		Bounds:   geo.NewBound(1, 2.5, 1.5, 2),
+		Nodes:    []latLon{{Lat: 1.5, Lon: 1}, {Lat: 2, Lon: 2.5, Entrance: 2, Wheelchair: 1}},
+	}
+	assert.Equal(t, `{"id":10,"type":"way","tags":{"building":"yes"},`+
+		`"centroid":{"lat":2,"lon":2.5,"type":"entrance"},"bounds":[1,1.5,2.5,2],`+
+		`"nodes":[{"lat":1.5,"lon":1},{"lat":2,"lon":2.5,"entrance":"main","wheelchair":"limited"}]}`, string(e.Way(&way)))
+
+	relation := jsonRelation{ID: 20, Type: "relation", Tags: map[string]string{}, Centroid: jsonCentroid{Lat: 5, Lon: 5}, Bounds: geo.NewBound(1, 2, 3, 4)}
+	assert.Equal(t, `{"id":20,"type":"relation","tags":{},"centroid":{"lat":5,"lon":5},"bounds":[1,3,2,4]}`, string(e.Relation(&relation)))
+}
+
+func TestAppendRounded(t *testing.T) {
+	assert.Equal(t, "45.5424694", string(appendRounded(nil, 45.54246941, 7)))
+	assert.Equal(t, "45.54", string(appendRounded(nil, 45.54, 7)))
+	assert.Equal(t, "-122.936", string(appendRounded(nil, -122.9356798, 3)))
+	assert.Equal(t, "46", string(appendRounded(nil, 45.5, 0)))
+	assert.Equal(t, "0", string(appendRounded(nil, -0.00000001, 7)))
+	assert.Equal(t, "0", string(appendRounded(nil, 0, 7)))
+}
+
+func TestRecordSchemaFile(t *testing.T) {
+	data, err := ioutil.ReadFile("schema/record.schema.json")
+	assert.Nil(t, err)
+
+	var schema struct {
+		Definitions map[string]interface{} `json:"definitions"`
+	}
+	assert.Nil(t, json.Unmarshal(data, &schema))
+	assert.Contains(t, schema.Definitions, "v1")
+	assert.Contains(t, schema.Definitions, "v2")
+}
+
+// a synthetic set of ways with 20 nodes each, decoded from cache bytes
+func syntheticWays(count int) []jsonWay {
+	rnd := rand.New(rand.NewSource(1))
+	ways := make([]jsonWay, count)
+	for i := range ways {
+		var latlons []latLon
+		for n := 0; n < 20; n++ {
+			_, data := nodeToBytes(&osmpbf.Node{Lat: rnd.Float64()*180 - 90, Lon: rnd.Float64()*360 - 180})
+			latlons = append(latlons, bytesToLatLon(data))
+		}
+		latlons = append(latlons, latlons[0])
+		centroid, bounds := computeCentroidAndBounds(latlons)
+		tags := map[string]string{"building": "yes", "name": "Building " + strconv.Itoa(i), "addr:street": "Main St", "addr:housenumber": strconv.Itoa(i)}
+		ways[i] = jsonWay{int64(i), "way", "", tags, centroid, bounds, nil, latlons, false, nil}
+	}
+	return ways
+}
+
+func createBenchmarkFile(b *testing.B) *os.File {
+	file, err := os.Create(filepath.Join(b.TempDir(), "records.json"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return file
+}
+
+// the previous output path, maps of formatted strings encoded by encoding/json
+// and printed to an unbuffered file
+func BenchmarkPrintWaysMarshal(b *testing.B) {
+	ways := syntheticWays(1000)
+	file := createBenchmarkFile(b)
+	defer file.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for n := 0; n < b.N; n++ {
+		record := toMapWay(&ways[n%len(ways)])
+		data, _ := json.Marshal(record)
+		fmt.Fprintln(file, string(data))
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "records/s")
+}
+
+// typed records encoded by the recordEncoder and printed to a buffered file
+func BenchmarkPrintWaysEncoder(b *testing.B) {
+	ways := syntheticWays(1000)
+	stream := openOutputStream(filepath.Join(b.TempDir(), "records.json"), compressNone)
+	defer stream.Close()
+	w := &streamWriter{stream}
+	e := &recordEncoder{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for n := 0; n < b.N; n++ {
+		way := &ways[n%len(ways)]
+		w.WriteRecord("way", way.ID, e.Way(way))
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "records/s")
+}
@@ -12,7 +12,7 @@ func TestEncodingSimple(t *testing.T) {
 
 	var node = &osmpbf.Node{ID: 100, Lat: -50, Lon: 77}
 	var expectedBytes = []byte{0xc0, 0x49, 0x0, 0x0, 0x0, 0x0, 0x40, 0x53, 0x40, 0x0, 0x0, 0x0}
-	var expectedLatlon = map[string]string{"lon": "77.0000000", "lat": "-50.0000000"}
+	var expectedLatlon = latLon{Lat: -50, Lon: 77}
 
 	// encode
 	var stringid, byteval = nodeToBytes(node)
@@ -28,7 +28,7 @@ func TestEncodingFloatPrecision(t *testing.T) {
 
 	var node = &osmpbf.Node{ID: 100, Lat: -50.555555555, Lon: 77.777777777}
 	var expectedBytes = []byte{0xc0, 0x49, 0x47, 0x1c, 0x71, 0xc5, 0x40, 0x53, 0x71, 0xc7, 0x1c, 0x70}
-	var expectedLatlon = map[string]string{"lon": "77.7777778", "lat": "-50.5555556"}
+	var expectedLatlon = latLon{Lat: -50.5555556, Lon: 77.7777778}
 
 	// encode
 	var stringid, byteval = nodeToBytes(node)
@@ -45,7 +45,7 @@ func TestEncodingBitmaskValues(t *testing.T) {
 	var tags = map[string]string{"entrance": "main", "wheelchair": "yes"}
 	var node = &osmpbf.Node{ID: 100, Lat: -50, Lon: 77, Tags: tags}
 	var expectedBytes = []byte{0xc0, 0x49, 0x0, 0x0, 0x0, 0x0, 0x40, 0x53, 0x40, 0x0, 0x0, 0x0, 0xa0}
-	var expectedLatlon = map[string]string{"lon": "77.0000000", "lat": "-50.0000000", "entrance": "2", "wheelchair": "2"}
+	var expectedLatlon = latLon{Lat: -50, Lon: 77, Entrance: 2, Wheelchair: 2}
 
 	// encode
 	var stringid, byteval = nodeToBytes(node)
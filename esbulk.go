@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/paulmach/go.geo"
+)
+
+// esBulkEncoder - encodes records for the elasticsearch _bulk API, each
+// record is an 'index' action line followed by a document line. Documents
+// have a 'geo_point' centroid and a 'geo_shape' envelope of the bounds.
+// note: the action and document are a single record, so rotated output
+// files never split them.
+type esBulkEncoder struct {
+	index string
+	json  *recordEncoder // encodes the tags and coordinates of documents
+}
+
+// newESBulkEncoder - constructor, coordinates are rounded to precision decimal places
+func newESBulkEncoder(index string, precision int) *esBulkEncoder {
+	return &esBulkEncoder{index: index, json: newRecordEncoder(schemaV2, precision)}
+}
+
+func (e *esBulkEncoder) Ext() string {
+	return ".ndjson"
+}
+
+func (e *esBulkEncoder) Header() []byte {
+	return nil
+}
+
+// Node - encode a node action and document, nodes have no bounds
+func (e *esBulkEncoder) Node(r *jsonNode) []byte {
+	b := e.appendAction(e.json.buf[:0], r.Type, r.ID)
+	b = e.appendDocument(b, r.ID, r.Type, r.Layer, r.Tags, jsonCentroid{Lat: r.Lat, Lon: r.Lon})
+	b = appendMetadata(b, r.jsonMetadata)
+	b = append(b, '}')
+	e.json.buf = b
+	return b
+}
+
+// Way - encode a way action and document
+func (e *esBulkEncoder) Way(r *jsonWay) []byte {
+	b := e.appendAction(e.json.buf[:0], r.Type, r.ID)
+	b = e.appendDocument(b, r.ID, r.Type, r.Layer, r.Tags, r.Centroid)
+	b = e.appendEnvelope(b, r.Bounds)
+	if r.Partial {
+		b = append(b, `,"partial":true`...)
+	}
+	b = appendMetadata(b, r.jsonMetadata)
+	b = append(b, '}')
+	e.json.buf = b
+	return b
+}
+
+// Relation - encode a relation action and document
+func (e *esBulkEncoder) Relation(r *jsonRelation) []byte {
+	b := e.appendAction(e.json.buf[:0], r.Type, r.ID)
+	b = e.appendDocument(b, r.ID, r.Type, r.Layer, r.Tags, r.Centroid)
+	b = e.appendEnvelope(b, r.Bounds)
+	if r.Partial {
+		b = append(b, `,"partial":true`...)
+	}
+	b = appendMetadata(b, r.jsonMetadata)
+	b = append(b, '}')
+	e.json.buf = b
+	return b
+}
+
+// append the action line, documents are identified as eg. 'node/123'
+func (e *esBulkEncoder) appendAction(b []byte, kind string, id int64) []byte {
+	b = append(b, `{"index":{"_index":`...)
+	b = appendString(b, e.index)
+	b = append(b, `,"_id":"`...)
+	b = append(b, kind...)
+	b = append(b, '/')
+	b = strconv.AppendInt(b, id, 10)
+	return append(b, "\"}}\n"...)
+}
+
+// append the fields common to all documents, the document is left open
+func (e *esBulkEncoder) appendDocument(b []byte, id int64, kind string, layer string, tags interface{}, centroid jsonCentroid) []byte {
+	b = append(b, `{"id":`...)
+	b = strconv.AppendInt(b, id, 10)
+	b = append(b, `,"type":`...)
+	b = appendString(b, kind)
+	b = appendLayer(b, layer)
+	b = append(b, `,"tags":`...)
+	b = e.json.appendTags(b, tags)
+
+	// a geo_point only accepts lat and lon, the type is a separate field
+	b = append(b, `,"centroid":{"lat":`...)
+	b = e.json.appendCoord(b, centroid.Lat)
+	b = append(b, `,"lon":`...)
+	b = e.json.appendCoord(b, centroid.Lon)
+	b = append(b, '}')
+	if centroid.Type != "" {
+		b = append(b, `,"centroid_type":`...)
+		b = appendString(b, centroid.Type)
+	}
+	return b
+}
+
+// append the bounds as a geo_shape envelope of [[west, north], [east, south]]
+func (e *esBulkEncoder) appendEnvelope(b []byte, bounds *geo.Bound) []byte {
+	if bounds == nil {
+		return b
+	}
+	b = append(b, `,"bounds":{"type":"envelope","coordinates":[[`...)
+	b = e.json.appendCoord(b, bounds.West())
+	b = append(b, ',')
+	b = e.json.appendCoord(b, bounds.North())
+	b = append(b, `],[`...)
+	b = e.json.appendCoord(b, bounds.East())
+	b = append(b, ',')
+	b = e.json.appendCoord(b, bounds.South())
+	return append(b, "]]}"...)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/go.geo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestESBulkNode(t *testing.T) {
+	e := newESBulkEncoder("osm", 7)
+	node := jsonNode{ID: 1, Type: "node", Layer: "venues", Lat: 1.5, Lon: -2.25, Tags: map[string]string{"amenity": "cafe"}}
+	assert.Equal(t, `{"index":{"_index":"osm","_id":"node/1"}}`+"\n"+
+		`{"id":1,"type":"node","layer":"venues","tags":{"amenity":"cafe"},"centroid":{"lat":1.5,"lon":-2.25}}`, string(e.Node(&node)))
+}
+
+func TestESBulkWay(t *testing.T) {
+	e := newESBulkEncoder("osm", 3)
+	way := jsonWay{
+		ID:       10,
+		Type:     "way",
+		Tags:     map[string]string{"building": "yes"},
+		Centroid: jsonCentroid{Lat: 1.23456, Lon: 2, Type: "entrance"},
+		Bounds:   geo.NewBound(1, 2, 3, 4),
+		Nodes:    []latLon{{Lat: 3, Lon: 1}, {Lat: 4, Lon: 2}},
+		Partial:  true,
+	}
+	assert.Equal(t, `{"index":{"_index":"osm","_id":"way/10"}}`+"\n"+
+		`{"id":10,"type":"way","tags":{"building":"yes"},"centroid":{"lat":1.235,"lon":2},"centroid_type":"entrance",`+
+		`"bounds":{"type":"envelope","coordinates":[[1,4],[2,3]]},"partial":true}`, string(e.Way(&way)))
+}
+
+func TestESBulkRelation(t *testing.T) {
+	e := newESBulkEncoder(`"quoted"`, 7)
+	relation := jsonRelation{ID: 20, Type: "relation", Tags: map[string]string{}, Centroid: jsonCentroid{Lat: 5, Lon: 5}, Bounds: geo.NewBound(1, 2, 3, 4)}
+	lines := strings.Split(string(e.Relation(&relation)), "\n")
+	assert.Len(t, lines, 2)
+
+	var action map[string]map[string]string
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &action))
+	assert.Equal(t, map[string]string{"_index": `"quoted"`, "_id": "relation/20"}, action["index"])
+	assert.True(t, json.Valid([]byte(lines[1])))
+}
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// FlatGeobuf constants, see https://flatgeobuf.org and its schema files
+// header.fbs and feature.fbs for the table field slots
+const (
+	fgbNodeSize   = 16 // items per node of the spatial index
+	fgbHilbertMax = 1<<16 - 1
+	fgbSRS        = 4326 // WGS 84 longitude/latitude
+
+	fgbSpillPrefix = "pbf2json-spill-" // features written before the index, not counted as part of the cache
+)
+
+// the magic bytes of FlatGeobuf version 3
+var fgbMagic = []byte{0x66, 0x67, 0x62, 0x03, 0x66, 0x67, 0x62, 0x00}
+
+// FlatGeobuf geometry types
+const (
+	fgbUnknown            = 0
+	fgbPoint              = 1
+	fgbLineString         = 2
+	fgbPolygon            = 3
+	fgbMultiLineString    = 5
+	fgbMultiPolygon       = 6
+	fgbGeometryCollection = 7
+)
+
+// FlatGeobuf column types
+const (
+	fgbBool     = 2
+	fgbInt      = 5
+	fgbLong     = 7
+	fgbDouble   = 10
+	fgbString   = 11
+	fgbJSON     = 12
+	fgbDateTime = 13
+)
+
+// the types of element fields selected with -columns, tags are strings
+var fgbFieldTypes = map[string]byte{
+	"id": fgbLong, "type": fgbString, "layer": fgbString, "lat": fgbDouble, "lon": fgbDouble,
+	"centroid.lat": fgbDouble, "centroid.lon": fgbDouble, "centroid.type": fgbString,
+	"bounds.n": fgbDouble, "bounds.s": fgbDouble, "bounds.e": fgbDouble, "bounds.w": fgbDouble,
+	"partial": fgbBool, "version": fgbInt, "timestamp": fgbDateTime, "changeset": fgbLong,
+	"uid": fgbInt, "user": fgbString, "visible": fgbBool,
+}
+
+// fgbItem - a feature written to the spill file, kept in memory to build the
+// index. Coordinates are the bounding box of the feature geometry.
+type fgbItem struct {
+	minX, minY, maxX, maxY float64
+	offset                 int64 // in the spill file
+	size                   uint32
+	hilbert                uint32
+}
+
+// flatGeobuf - writes records as the features of a FlatGeobuf file. The
+// header and the index have to be written before the features, so features
+// are spilled to a temporary file as they are printed and copied to the
+// output in index order when it is closed. Only the bounding box and spill
+// offset of each feature is kept in memory.
+// Nodes are points, ways with node lat/lons are a line or polygon and relations
+// the geometry assembled from their member ways, otherwise their centroid.
+type flatGeobuf struct {
+	path    string
+	out     *outputStream
+	columns []csvColumn // nil writes the tags as a single json column
+	index   bool
+	spill   *os.File
+	w       *bufio.Writer
+	size    int64 // bytes spilled
+	items   []fgbItem
+	types   uint8 // a bit per geometry type written
+	builder *flatbuffers.Builder
+	json    *recordEncoder // encodes the tags
+	file    *outputFile    // the features written, sized once closed. nil for stdout
+	xy      []float64
+	ends    []uint32
+	props   []byte
+	value   []byte
+}
+
+// openFlatGeobufs - open a FlatGeobuf for the output path of each layer,
+// layers which don't specify an output path are written to path (or stdout).
+// Files are written on exit, spillDir holds the features until then. The
+// files are returned, excluding stdout.
+func openFlatGeobufs(profiles []*profile, path string, columns []csvColumn, index bool, spillDir string) []*outputFile {
+	var outputs []*outputFile
+	if path == "" {
+		path = "-"
+	}
+	files := make(map[string]*flatGeobuf)
+	for _, p := range profiles {
+		for _, l := range p.Layers {
+			output := l.Output
+			if output == "" {
+				output = path
+			}
+			if _, ok := files[output]; !ok {
+				files[output] = openFlatGeobuf(output, columns, index, spillDir)
+				addCleanup(files[output].Close)
+				if files[output].file != nil {
+					outputs = append(outputs, files[output].file)
+				}
+			}
+			l.out = files[output]
+		}
+	}
+	return outputs
+}
+
+// openFlatGeobuf - create a FlatGeobuf at path, '-' writes to stdout
+func openFlatGeobuf(path string, columns []csvColumn, index bool, spillDir string) *flatGeobuf {
+	if err := os.MkdirAll(spillDir, 0755); err != nil {
+		fatal(err)
+	}
+	spill, err := ioutil.TempFile(spillDir, fgbSpillPrefix+"*.fgb")
+	if err != nil {
+		fatal(err)
+	}
+	addCleanup(func() error {
+		spill.Close()
+		return os.Remove(spill.Name())
+	})
+	var file *outputFile
+	if path != "-" {
+		file = &outputFile{Path: path}
+	}
+	return &flatGeobuf{
+		path:    path,
+		file:    file,
+		out:     openOutputStream(path, compressNone),
+		columns: columns,
+		index:   index,
+		spill:   spill,
+		w:       bufio.NewWriterSize(spill, 1<<20),
+		builder: flatbuffers.NewBuilder(1024),
+		json:    newRecordEncoder(schemaV1, defaultPrecision),
+	}
+}
+
+// Node - write a node as a point
+func (f *flatGeobuf) Node(r *jsonNode) bool {
+	f.write(nil, &csvRecord{r.ID, r.Type, r.Layer, r.Tags, jsonCentroid{Lat: r.Lat, Lon: r.Lon}, nil, false, r.jsonMetadata})
+	return true
+}
+
+// Way - write a way, as a line or polygon if its nodes are available
+func (f *flatGeobuf) Way(r *jsonWay) bool {
+	f.write(wayShape(r.Nodes), &csvRecord{r.ID, r.Type, r.Layer, r.Tags, r.Centroid, r.Bounds, r.Partial, r.jsonMetadata})
+	return true
+}
+
+// Relation - write a relation, as the geometry assembled from its member ways if available
+func (f *flatGeobuf) Relation(r *jsonRelation) bool {
+	f.write(r.Geometry, &csvRecord{r.ID, r.Type, r.Layer, r.Tags, r.Centroid, r.Bounds, r.Partial, r.jsonMetadata})
+	return true
+}
+
+// spill a feature with the geometry of s, or a point at the centroid when s is nil
+func (f *flatGeobuf) write(s *shape, r *csvRecord) {
+	item := fgbItem{minX: math.Inf(1), minY: math.Inf(1), maxX: math.Inf(-1), maxY: math.Inf(-1), offset: f.size}
+
+	f.props = f.appendProperties(f.props[:0], r)
+	b := f.builder
+	b.Reset()
+	properties := b.CreateByteVector(f.props)
+	var geometryType uint8
+	var geometry flatbuffers.UOffsetT
+	if s == nil {
+		geometryType = fgbPoint
+		f.xy = append(f.xy[:0], r.centroid.Lon, r.centroid.Lat)
+		geometry = f.geometry(fgbPoint, nil, &item)
+	} else {
+		geometryType, geometry = f.shapeGeometry(s, &item)
+	}
+	f.types |= 1 << geometryType
+
+	// Feature table
+	b.StartObject(3)
+	b.PrependUOffsetTSlot(1, properties, 0)
+	b.PrependUOffsetTSlot(0, geometry, 0)
+	b.Finish(b.EndObject())
+
+	feature := b.FinishedBytes()
+	item.size = uint32(4 + len(feature))
+	if _, err := f.w.Write(appendUint32(f.value[:0], uint32(len(feature)))); err != nil {
+		fatalf("%s: %v", f.spill.Name(), err)
+	}
+	if _, err := f.w.Write(feature); err != nil {
+		fatalf("%s: %v", f.spill.Name(), err)
+	}
+	f.size += int64(item.size)
+	f.items = append(f.items, item)
+}
+
+// add the Geometry table of a shape, a single polygon or line unless there
+// are several, as it is written as WKB
+func (f *flatGeobuf) shapeGeometry(s *shape, item *fgbItem) (uint8, flatbuffers.UOffsetT) {
+	if len(s.polygons) > 0 && len(s.lines) > 0 {
+		_, polygons := f.shapeGeometry(&shape{polygons: s.polygons}, item)
+		_, lines := f.shapeGeometry(&shape{lines: s.lines}, item)
+		return fgbGeometryCollection, f.geometry(fgbGeometryCollection, []flatbuffers.UOffsetT{polygons, lines}, item)
+	}
+	switch {
+	case len(s.polygons) == 1:
+		f.setLines(s.polygons[0])
+		return fgbPolygon, f.geometry(fgbPolygon, nil, item)
+	case len(s.polygons) > 1:
+		parts := make([]flatbuffers.UOffsetT, len(s.polygons))
+		for i, polygon := range s.polygons {
+			f.setLines(polygon)
+			parts[i] = f.geometry(fgbPolygon, nil, item)
+		}
+		return fgbMultiPolygon, f.geometry(fgbMultiPolygon, parts, item)
+	case len(s.lines) == 1:
+		f.setLines(s.lines)
+		return fgbLineString, f.geometry(fgbLineString, nil, item)
+	default:
+		f.setLines(s.lines)
+		return fgbMultiLineString, f.geometry(fgbMultiLineString, nil, item)
+	}
+}
+
+// set f.xy to the coordinates of the rings or lines, and f.ends to the end of each
+func (f *flatGeobuf) setLines(lines [][]latLon) {
+	f.xy, f.ends = f.xy[:0], f.ends[:0]
+	for _, line := range lines {
+		for _, latlon := range line {
+			f.xy = append(f.xy, latlon.Lon, latlon.Lat)
+		}
+		f.ends = append(f.ends, uint32(len(f.xy)/2))
+	}
+}
+
+// add a Geometry table of the coordinates in f.xy, or of parts, and expand
+// item by the coordinates. The ends of polygon rings are always written,
+// line ends only when there are several lines.
+func (f *flatGeobuf) geometry(geometryType uint8, parts []flatbuffers.UOffsetT, item *fgbItem) flatbuffers.UOffsetT {
+	b := f.builder
+	var partsOffset, ends, xy flatbuffers.UOffsetT
+	if len(parts) > 0 {
+		b.StartVector(4, len(parts), 4)
+		for i := len(parts) - 1; i >= 0; i-- {
+			b.PrependUOffsetT(parts[i])
+		}
+		partsOffset = b.EndVector(len(parts))
+	} else {
+		if geometryType == fgbPolygon || (geometryType == fgbMultiLineString && len(f.ends) > 1) {
+			b.StartVector(4, len(f.ends), 4)
+			for i := len(f.ends) - 1; i >= 0; i-- {
+				b.PrependUint32(f.ends[i])
+			}
+			ends = b.EndVector(len(f.ends))
+		}
+		b.StartVector(8, len(f.xy), 8)
+		for i := len(f.xy) - 1; i >= 0; i-- {
+			b.PrependFloat64(f.xy[i])
+		}
+		xy = b.EndVector(len(f.xy))
+		for i := 0; i < len(f.xy); i += 2 {
+			item.minX, item.maxX = math.Min(item.minX, f.xy[i]), math.Max(item.maxX, f.xy[i])
+			item.minY, item.maxY = math.Min(item.minY, f.xy[i+1]), math.Max(item.maxY, f.xy[i+1])
+		}
+	}
+
+	// Geometry table
+	b.StartObject(8)
+	if partsOffset != 0 {
+		b.PrependUOffsetTSlot(7, partsOffset, 0)
+	}
+	b.PrependUint8Slot(6, geometryType, fgbUnknown)
+	if xy != 0 {
+		b.PrependUOffsetTSlot(1, xy, 0)
+	}
+	if ends != 0 {
+		b.PrependUOffsetTSlot(0, ends, 0)
+	}
+	return b.EndObject()
+}
+
+// append the feature properties, each value follows the uint16 index of its
+// column and values which a record doesn't have are left out
+func (f *flatGeobuf) appendProperties(b []byte, r *csvRecord) []byte {
+	if f.columns == nil {
+		b = appendFGBColumn(b, 0)
+		b = appendFGBLong(b, r.id)
+		b = appendFGBColumn(b, 1)
+		b = appendFGBString(b, []byte(r.kind))
+		if r.layer != "" {
+			b = appendFGBColumn(b, 2)
+			b = appendFGBString(b, []byte(r.layer))
+		}
+		f.value = f.json.appendTags(f.value[:0], r.tags)
+		b = appendFGBColumn(b, 3)
+		return appendFGBString(b, f.value)
+	}
+
+	for i, c := range f.columns {
+		if c.tag != "" {
+			f.value = appendTagValue(f.value[:0], r.tags, c.tag)
+			if len(f.value) > 0 {
+				b = appendFGBColumn(b, i)
+				b = appendFGBString(b, f.value)
+			}
+			continue
+		}
+		b = f.appendField(b, i, c.field, r)
+	}
+	return b
+}
+
+// append the value of an element field, nothing if the record doesn't have it
+func (f *flatGeobuf) appendField(b []byte, i int, field string, r *csvRecord) []byte {
+	switch field {
+	case "id":
+		return appendFGBLong(appendFGBColumn(b, i), r.id)
+	case "type":
+		return appendFGBString(appendFGBColumn(b, i), []byte(r.kind))
+	case "lat", "centroid.lat":
+		return appendFGBDouble(appendFGBColumn(b, i), r.centroid.Lat)
+	case "lon", "centroid.lon":
+		return appendFGBDouble(appendFGBColumn(b, i), r.centroid.Lon)
+	case "layer":
+		if r.layer != "" {
+			return appendFGBString(appendFGBColumn(b, i), []byte(r.layer))
+		}
+		return b
+	case "centroid.type":
+		if r.centroid.Type != "" {
+			return appendFGBString(appendFGBColumn(b, i), []byte(r.centroid.Type))
+		}
+		return b
+	}
+
+	if r.bounds != nil {
+		switch field {
+		case "bounds.n":
+			return appendFGBDouble(appendFGBColumn(b, i), r.bounds.North())
+		case "bounds.s":
+			return appendFGBDouble(appendFGBColumn(b, i), r.bounds.South())
+		case "bounds.e":
+			return appendFGBDouble(appendFGBColumn(b, i), r.bounds.East())
+		case "bounds.w":
+			return appendFGBDouble(appendFGBColumn(b, i), r.bounds.West())
+		case "partial":
+			return appendFGBBool(appendFGBColumn(b, i), r.partial)
+		}
+	}
+
+	if meta := r.meta; meta != nil {
+		switch {
+		case field == "version" && meta.Version != nil:
+			return appendUint32(appendFGBColumn(b, i), uint32(int32(*meta.Version)))
+		case field == "timestamp" && meta.Timestamp != nil:
+			f.value = meta.Timestamp.UTC().AppendFormat(f.value[:0], time.RFC3339Nano)
+			return appendFGBString(appendFGBColumn(b, i), f.value)
+		case field == "changeset" && meta.Changeset != nil:
+			return appendFGBLong(appendFGBColumn(b, i), *meta.Changeset)
+		case field == "uid" && meta.UID != nil:
+			return appendUint32(appendFGBColumn(b, i), uint32(int32(*meta.UID)))
+		case field == "user" && meta.User != nil:
+			return appendFGBString(appendFGBColumn(b, i), []byte(*meta.User))
+		case field == "visible" && meta.Visible != nil:
+			return appendFGBBool(appendFGBColumn(b, i), *meta.Visible)
+		}
+	}
+	return b
+}
+
+// Close - write the header, index and the spilled features to the output.
+// note: write errors are sticky, they are returned when the output is closed
+func (f *flatGeobuf) Close() error {
+	if f.out == nil {
+		return nil
+	}
+	out := f.out
+	f.out = nil
+	start := time.Now()
+	if err := f.w.Flush(); err != nil {
+		out.Close()
+		return fmt.Errorf("%s: %v", f.spill.Name(), err)
+	}
+
+	// the dataset extent, features are sorted along a hilbert curve across it
+	extent := fgbItem{minX: math.Inf(1), minY: math.Inf(1), maxX: math.Inf(-1), maxY: math.Inf(-1)}
+	for i := range f.items {
+		extent.expand(&f.items[i])
+	}
+	index := f.index && len(f.items) > 0
+	if index {
+		width, height := extent.maxX-extent.minX, extent.maxY-extent.minY
+		for i := range f.items {
+			item := &f.items[i]
+			var x, y uint32
+			if width > 0 {
+				x = uint32(fgbHilbertMax * ((item.minX+item.maxX)/2 - extent.minX) / width)
+			}
+			if height > 0 {
+				y = uint32(fgbHilbertMax * ((item.minY+item.maxY)/2 - extent.minY) / height)
+			}
+			item.hilbert = hilbert(x, y)
+		}
+		sort.Slice(f.items, func(i, j int) bool { return f.items[i].hilbert < f.items[j].hilbert })
+	}
+
+	out.Write(fgbMagic)
+	out.Write(f.header(&extent, index))
+	if index {
+		f.writeIndex(out)
+	}
+
+	// copy the features in index order
+	var buf []byte
+	for _, item := range f.items {
+		if cap(buf) < int(item.size) {
+			buf = make([]byte, item.size)
+		}
+		buf = buf[:item.size]
+		if _, err := f.spill.ReadAt(buf, item.offset); err != nil {
+			out.Close()
+			return fmt.Errorf("%s: %v", f.spill.Name(), err)
+		}
+		out.Write(buf)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("%s: %v", f.path, err)
+	}
+	if f.file != nil {
+		info, err := os.Stat(f.path)
+		if err != nil {
+			return err
+		}
+		f.file.Records, f.file.Bytes = int64(len(f.items)), info.Size()
+	}
+	log.Printf("[info] wrote %d features to %s in %s\n", len(f.items), f.path, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// the size prefixed header
+func (f *flatGeobuf) header(extent *fgbItem, index bool) []byte {
+	b := flatbuffers.NewBuilder(1024)
+
+	name := "pbf2json"
+	if f.path != "-" {
+		name = strings.TrimSuffix(filepath.Base(f.path), filepath.Ext(f.path))
+	}
+	nameOffset := b.CreateString(name)
+
+	var envelope flatbuffers.UOffsetT
+	if len(f.items) > 0 {
+		b.StartVector(8, 4, 8)
+		for _, v := range []float64{extent.maxY, extent.maxX, extent.minY, extent.minX} {
+			b.PrependFloat64(v)
+		}
+		envelope = b.EndVector(4)
+	}
+
+	// Column tables
+	var columns []flatbuffers.UOffsetT
+	if f.columns == nil {
+		columns = append(columns,
+			fgbColumn(b, "id", fgbLong, false),
+			fgbColumn(b, "type", fgbString, false),
+			fgbColumn(b, "layer", fgbString, true),
+			fgbColumn(b, "tags", fgbJSON, false))
+	}
+	for _, c := range f.columns {
+		columnType := uint8(fgbString)
+		if c.field != "" {
+			columnType = fgbFieldTypes[c.field]
+		}
+		columns = append(columns, fgbColumn(b, c.name, columnType, c.field != "id" && c.field != "type"))
+	}
+	b.StartVector(4, len(columns), 4)
+	for i := len(columns) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(columns[i])
+	}
+	columnsOffset := b.EndVector(len(columns))
+
+	// Crs table
+	org := b.CreateString("EPSG")
+	b.StartObject(6)
+	b.PrependInt32Slot(1, fgbSRS, 0)
+	b.PrependUOffsetTSlot(0, org, 0)
+	crs := b.EndObject()
+
+	// the geometry type, unknown when features have different types
+	geometryType := uint8(fgbUnknown)
+	for t := uint8(fgbPoint); t <= fgbGeometryCollection; t++ {
+		if f.types == 1<<t {
+			geometryType = t
+		}
+	}
+
+	// Header table
+	nodeSize := uint16(0)
+	if index {
+		nodeSize = fgbNodeSize
+	}
+	b.StartObject(14)
+	b.PrependUint64Slot(8, uint64(len(f.items)), 0)
+	b.PrependUOffsetTSlot(10, crs, 0)
+	b.PrependUOffsetTSlot(7, columnsOffset, 0)
+	if envelope != 0 {
+		b.PrependUOffsetTSlot(1, envelope, 0)
+	}
+	b.PrependUOffsetTSlot(0, nameOffset, 0)
+	b.PrependUint16Slot(9, nodeSize, fgbNodeSize)
+	b.PrependUint8Slot(2, geometryType, fgbUnknown)
+	b.Finish(b.EndObject())
+
+	header := b.FinishedBytes()
+	return append(appendUint32(nil, uint32(len(header))), header...)
+}
+
+// a Column table
+func fgbColumn(b *flatbuffers.Builder, name string, columnType uint8, nullable bool) flatbuffers.UOffsetT {
+	nameOffset := b.CreateString(name)
+	b.StartObject(11)
+	b.PrependUOffsetTSlot(0, nameOffset, 0)
+	b.PrependUint8Slot(1, columnType, 0)
+	b.PrependBoolSlot(7, nullable, true)
+	return b.EndObject()
+}
+
+// write the packed hilbert R-tree of the sorted features. Nodes are stored
+// from the root down, each level after the one above it and the leaves last.
+// The offset of a leaf is the position of its feature after the index, the
+// offset of any other node is the position of its first child node.
+func (f *flatGeobuf) writeIndex(out *outputStream) {
+	levels := fgbLevelBounds(len(f.items), fgbNodeSize)
+	first := levels[0][0] // the position of the first leaf
+	nodes := make([]fgbItem, first)
+
+	// the bounds of each node above the leaves
+	node := func(i int) *fgbItem {
+		if i < first {
+			return &nodes[i]
+		}
+		return &f.items[i-first]
+	}
+	for level := 0; level < len(levels)-1; level++ {
+		pos, end := levels[level][0], levels[level][1]
+		for parent := levels[level+1][0]; parent < levels[level+1][1]; parent++ {
+			n := node(parent)
+			*n = fgbItem{minX: math.Inf(1), minY: math.Inf(1), maxX: math.Inf(-1), maxY: math.Inf(-1), offset: int64(pos)}
+			for j := 0; j < fgbNodeSize && pos < end; j++ {
+				n.expand(node(pos))
+				pos++
+			}
+		}
+	}
+
+	var b []byte
+	for i := range nodes {
+		b = appendFGBNode(b[:0], &nodes[i], uint64(nodes[i].offset))
+		out.Write(b)
+	}
+	var offset uint64
+	for i := range f.items {
+		b = appendFGBNode(b[:0], &f.items[i], offset)
+		out.Write(b)
+		offset += uint64(f.items[i].size)
+	}
+}
+
+// fgbLevelBounds - the range of node positions of each level of an index,
+// starting with the leaves
+func fgbLevelBounds(items int, nodeSize int) [][2]int {
+	n, total := items, items
+	counts := []int{n}
+	for {
+		n = (n + nodeSize - 1) / nodeSize
+		total += n
+		counts = append(counts, n)
+		if n == 1 {
+			break
+		}
+	}
+	levels := make([][2]int, len(counts))
+	for i, count := range counts {
+		total -= count
+		levels[i] = [2]int{total, total + count}
+	}
+	return levels
+}
+
+// expand the bounding box to include another
+func (item *fgbItem) expand(other *fgbItem) {
+	item.minX, item.minY = math.Min(item.minX, other.minX), math.Min(item.minY, other.minY)
+	item.maxX, item.maxY = math.Max(item.maxX, other.maxX), math.Max(item.maxY, other.maxY)
+}
+
+func appendFGBNode(b []byte, item *fgbItem, offset uint64) []byte {
+	b = appendFloat64(b, item.minX)
+	b = appendFloat64(b, item.minY)
+	b = appendFloat64(b, item.maxX)
+	b = appendFloat64(b, item.maxY)
+	return appendUint64(b, offset)
+}
+
+func appendFGBColumn(b []byte, i int) []byte {
+	return append(b, byte(i), byte(i>>8))
+}
+
+func appendFGBLong(b []byte, v int64) []byte {
+	return appendUint64(b, uint64(v))
+}
+
+func appendFGBDouble(b []byte, v float64) []byte {
+	return appendFloat64(b, v)
+}
+
+func appendFGBBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// strings, json and date times are prefixed with their length
+func appendFGBString(b []byte, v []byte) []byte {
+	return append(appendUint32(b, uint32(len(v))), v...)
+}
+
+// hilbert - the position of x, y along a hilbert curve, both are 16 bits.
+// see https://github.com/rawrunprotected/hilbert_curves
+func hilbert(x uint32, y uint32) uint32 {
+	a := x ^ y
+	b := 0xFFFF ^ a
+	c := 0xFFFF ^ (x | y)
+	d := x & (y ^ 0xFFFF)
+
+	A := a | (b >> 1)
+	B := (a >> 1) ^ a
+	C := ((c >> 1) ^ (b & (d >> 1))) ^ c
+	D := ((a & (c >> 1)) ^ (d >> 1)) ^ d
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 2)) ^ (b & (b >> 2))
+	B = (a & (b >> 2)) ^ (b & ((a ^ b) >> 2))
+	C ^= (a & (c >> 2)) ^ (b & (d >> 2))
+	D ^= (b & (c >> 2)) ^ ((a ^ b) & (d >> 2))
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 4)) ^ (b & (b >> 4))
+	B = (a & (b >> 4)) ^ (b & ((a ^ b) >> 4))
+	C ^= (a & (c >> 4)) ^ (b & (d >> 4))
+	D ^= (b & (c >> 4)) ^ ((a ^ b) & (d >> 4))
+
+	a, b, c, d = A, B, C, D
+	C ^= (a & (c >> 8)) ^ (b & (d >> 8))
+	D ^= (b & (c >> 8)) ^ ((a ^ b) & (d >> 8))
+
+	a = C ^ (C >> 1)
+	b = D ^ (D >> 1)
+
+	i0 := x ^ y
+	i1 := b | (0xFFFF ^ (i0 | a))
+
+	return (interleave(i1) << 1) | interleave(i0)
+}
+
+// spread the low 16 bits of v to the even bits
+func interleave(v uint32) uint32 {
+	v = (v | (v << 8)) & 0x00FF00FF
+	v = (v | (v << 4)) & 0x0F0F0F0F
+	v = (v | (v << 2)) & 0x33333333
+	return (v | (v << 1)) & 0x55555555
+}
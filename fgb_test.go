@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/paulmach/go.geo"
+	"github.com/stretchr/testify/assert"
+)
+
+// fgbFile - the parts of a FlatGeobuf file read back by the tests
+type fgbFile struct {
+	header   *flatbuffers.Table
+	index    []fgbItem // the offset of each node as written
+	features map[int64]*flatbuffers.Table
+}
+
+func readFlatGeobuf(t *testing.T, path string) *fgbFile {
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, fgbMagic, data[:8])
+
+	size := binary.LittleEndian.Uint32(data[8:])
+	f := &fgbFile{header: fgbRoot(data[12 : 12+size]), features: make(map[int64]*flatbuffers.Table)}
+	pos := 12 + int(size)
+	if nodeSize := f.header.GetUint16Slot(fgbSlot(9), fgbNodeSize); nodeSize > 0 {
+		levels := fgbLevelBounds(int(f.header.GetUint64Slot(fgbSlot(8), 0)), int(nodeSize))
+		for i := 0; i < levels[0][1]; i++ {
+			b := data[pos+i*40:]
+			f.index = append(f.index, fgbItem{
+				minX:   math.Float64frombits(binary.LittleEndian.Uint64(b)),
+				minY:   math.Float64frombits(binary.LittleEndian.Uint64(b[8:])),
+				maxX:   math.Float64frombits(binary.LittleEndian.Uint64(b[16:])),
+				maxY:   math.Float64frombits(binary.LittleEndian.Uint64(b[24:])),
+				offset: int64(binary.LittleEndian.Uint64(b[32:])),
+			})
+		}
+		pos += 40 * levels[0][1]
+	}
+
+	// features by their offset after the index
+	for offset := pos; offset < len(data); {
+		size := int(binary.LittleEndian.Uint32(data[offset:]))
+		f.features[int64(offset-pos)] = fgbRoot(data[offset+4 : offset+4+size])
+		offset += 4 + size
+	}
+	return f
+}
+
+func fgbRoot(b []byte) *flatbuffers.Table {
+	return &flatbuffers.Table{Bytes: b, Pos: flatbuffers.GetUOffsetT(b)}
+}
+
+func fgbSlot(i int) flatbuffers.VOffsetT {
+	return flatbuffers.VOffsetT(4 + 2*i)
+}
+
+func fgbField(tab *flatbuffers.Table, i int) flatbuffers.UOffsetT {
+	return flatbuffers.UOffsetT(tab.Offset(fgbSlot(i)))
+}
+
+func fgbSubTable(tab *flatbuffers.Table, i int) *flatbuffers.Table {
+	return &flatbuffers.Table{Bytes: tab.Bytes, Pos: tab.Indirect(tab.Pos + fgbField(tab, i))}
+}
+
+func fgbStringField(tab *flatbuffers.Table, i int) string {
+	return string(tab.ByteVector(tab.Pos + fgbField(tab, i)))
+}
+
+func fgbDoubles(tab *flatbuffers.Table, i int) []float64 {
+	o := fgbField(tab, i)
+	if o == 0 {
+		return nil
+	}
+	values := make([]float64, tab.VectorLen(o))
+	for j := range values {
+		values[j] = tab.GetFloat64(tab.Vector(o) + flatbuffers.UOffsetT(8*j))
+	}
+	return values
+}
+
+// the names of the header columns
+func fgbColumnNames(header *flatbuffers.Table) []string {
+	o := fgbField(header, 7)
+	var names []string
+	for j := 0; j < header.VectorLen(o); j++ {
+		column := &flatbuffers.Table{Bytes: header.Bytes, Pos: header.Indirect(header.Vector(o) + flatbuffers.UOffsetT(4*j))}
+		names = append(names, fgbStringField(column, 0))
+	}
+	return names
+}
+
+// the encoded value of each property of a feature by column index
+func fgbProperties(feature *flatbuffers.Table, types []byte) map[int][]byte {
+	b := feature.ByteVector(feature.Pos + fgbField(feature, 1))
+	properties := make(map[int][]byte)
+	for len(b) > 0 {
+		i := int(binary.LittleEndian.Uint16(b))
+		size := map[byte]int{fgbBool: 1, fgbInt: 4, fgbLong: 8, fgbDouble: 8}[types[i]]
+		if size == 0 {
+			properties[i] = b[6 : 6+binary.LittleEndian.Uint32(b[2:])]
+			b = b[6+len(properties[i]):]
+			continue
+		}
+		properties[i] = b[2 : 2+size]
+		b = b[2+size:]
+	}
+	return properties
+}
+
+// search the index as a FlatGeobuf reader does, returning feature offsets
+func (f *fgbFile) search(minX, minY, maxX, maxY float64) map[int64]bool {
+	levels := fgbLevelBounds(len(f.features), fgbNodeSize)
+	found := make(map[int64]bool)
+	var visit func(node int, level int)
+	visit = func(node int, level int) {
+		end := node + fgbNodeSize
+		if end > levels[level][1] {
+			end = levels[level][1]
+		}
+		for i := node; i < end; i++ {
+			n := f.index[i]
+			if maxX < n.minX || maxY < n.minY || minX > n.maxX || minY > n.maxY {
+				continue
+			}
+			if level == 0 {
+				found[n.offset] = true
+			} else {
+				visit(int(n.offset), level-1)
+			}
+		}
+	}
+	visit(0, len(levels)-1)
+	return found
+}
+
+func TestFGBLevelBounds(t *testing.T) {
+	assert.Equal(t, [][2]int{{1, 2}, {0, 1}}, fgbLevelBounds(1, 16))
+	assert.Equal(t, [][2]int{{1, 17}, {0, 1}}, fgbLevelBounds(16, 16))
+	assert.Equal(t, [][2]int{{4, 44}, {1, 4}, {0, 1}}, fgbLevelBounds(40, 16))
+}
+
+func TestHilbert(t *testing.T) {
+	assert.Equal(t, uint32(0), hilbert(0, 0))
+
+	// neighbouring positions along the curve are neighbouring cells
+	cells := make(map[uint32][2]uint32)
+	for x := uint32(0); x < 64; x++ {
+		for y := uint32(0); y < 64; y++ {
+			cells[hilbert(x<<10, y<<10)>>20] = [2]uint32{x, y}
+		}
+	}
+	assert.Len(t, cells, 64*64)
+	for i := uint32(1); i < 64*64; i++ {
+		a, b := cells[i-1], cells[i]
+		dx, dy := int(a[0])-int(b[0]), int(a[1])-int(b[1])
+		assert.Equal(t, 1, dx*dx+dy*dy)
+	}
+}
+
+func TestFlatGeobuf(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "records.fgb")
+	f := openFlatGeobuf(path, nil, true, dir)
+
+	assert.True(t, f.Node(&jsonNode{ID: 1, Type: "node", Layer: "venues", Lat: 1.5, Lon: -2.25, Tags: map[string]string{"amenity": "cafe"}}))
+	ring := []latLon{{Lat: 1, Lon: 1}, {Lat: 1, Lon: 2}, {Lat: 2, Lon: 2}, {Lat: 1, Lon: 1}}
+	assert.True(t, f.Way(&jsonWay{ID: 10, Type: "way", Tags: map[string]string{"building": "yes"}, Nodes: ring}))
+	assert.True(t, f.Way(&jsonWay{ID: 11, Type: "way", Tags: map[string]string{}, Nodes: ring[:3]}))
+	assert.True(t, f.Way(&jsonWay{ID: 12, Type: "way", Tags: map[string]string{}, Centroid: jsonCentroid{Lat: 3, Lon: 4}}))
+	assert.True(t, f.Relation(&jsonRelation{ID: 20, Type: "relation", Tags: map[string]interface{}{"name": []string{"a", "b"}}, Centroid: jsonCentroid{Lat: 5, Lon: 6}}))
+
+	// enough features for several levels of the index
+	random := rand.New(rand.NewSource(1))
+	for id := int64(100); id < 400; id++ {
+		f.Node(&jsonNode{ID: id, Type: "node", Lat: random.Float64()*170 - 85, Lon: random.Float64()*360 - 180, Tags: map[string]string{}})
+	}
+	assert.Nil(t, f.Close())
+
+	file := readFlatGeobuf(t, path)
+	assert.Equal(t, "records", fgbStringField(file.header, 0))
+	assert.Equal(t, uint8(fgbUnknown), file.header.GetUint8Slot(fgbSlot(2), fgbUnknown))
+	assert.Equal(t, uint64(305), file.header.GetUint64Slot(fgbSlot(8), 0))
+	assert.Equal(t, []string{"id", "type", "layer", "tags"}, fgbColumnNames(file.header))
+	crs := fgbSubTable(file.header, 10)
+	assert.Equal(t, "EPSG", fgbStringField(crs, 0))
+	assert.Equal(t, int32(4326), crs.GetInt32Slot(fgbSlot(1), 0))
+	assert.Len(t, file.features, 305)
+
+	// features by id
+	types := []byte{fgbLong, fgbString, fgbString, fgbJSON}
+	features := make(map[int64]*flatbuffers.Table)
+	for _, feature := range file.features {
+		properties := fgbProperties(feature, types)
+		features[int64(binary.LittleEndian.Uint64(properties[0]))] = feature
+	}
+
+	node := fgbProperties(features[1], types)
+	assert.Equal(t, "node", string(node[1]))
+	assert.Equal(t, "venues", string(node[2]))
+	assert.Equal(t, `{"amenity":"cafe"}`, string(node[3]))
+	geometry := fgbSubTable(features[1], 0)
+	assert.Equal(t, uint8(fgbPoint), geometry.GetUint8Slot(fgbSlot(6), 0))
+	assert.Equal(t, []float64{-2.25, 1.5}, fgbDoubles(geometry, 1))
+
+	geometry = fgbSubTable(features[10], 0)
+	assert.Equal(t, uint8(fgbPolygon), geometry.GetUint8Slot(fgbSlot(6), 0))
+	assert.Equal(t, []float64{1, 1, 2, 1, 2, 2, 1, 1}, fgbDoubles(geometry, 1))
+	ends := fgbField(geometry, 0)
+	assert.Equal(t, uint32(4), geometry.GetUint32(geometry.Vector(ends)))
+
+	geometry = fgbSubTable(features[11], 0)
+	assert.Equal(t, uint8(fgbLineString), geometry.GetUint8Slot(fgbSlot(6), 0))
+	assert.Equal(t, flatbuffers.UOffsetT(0), fgbField(geometry, 0))
+
+	// ways without nodes and relations are their centroid
+	assert.Equal(t, []float64{4, 3}, fgbDoubles(fgbSubTable(features[12], 0), 1))
+	relation := fgbProperties(features[20], types)
+	assert.NotContains(t, relation, 2)
+	assert.Equal(t, `{"name":["a","b"]}`, string(relation[3]))
+	assert.Equal(t, []float64{6, 5}, fgbDoubles(fgbSubTable(features[20], 0), 1))
+
+	// the index finds the same features as a scan
+	for i := 0; i < 50; i++ {
+		x, y := random.Float64()*360-180, random.Float64()*170-85
+		minX, minY, maxX, maxY := x, y, x+random.Float64()*60, y+random.Float64()*40
+		expected := make(map[int64]bool)
+		for offset, feature := range file.features {
+			xy := fgbDoubles(fgbSubTable(feature, 0), 1)
+			b := geo.NewBound(xy[0], xy[0], xy[1], xy[1])
+			for j := 2; j < len(xy); j += 2 {
+				b.Extend(geo.NewPoint(xy[j], xy[j+1]))
+			}
+			if maxX >= b.West() && maxY >= b.South() && minX <= b.East() && minY <= b.North() {
+				expected[offset] = true
+			}
+		}
+		assert.Equal(t, expected, file.search(minX, minY, maxX, maxY))
+	}
+}
+
+func TestFlatGeobufColumns(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "records.fgb")
+	columns, _ := parseColumns("id,name,bounds.n,partial,version,timestamp")
+	f := openFlatGeobuf(path, columns, false, dir)
+
+	version, ts := int32(3), time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	f.Node(&jsonNode{ID: 1, Type: "node", Tags: map[string]string{"name": "cafe"}, jsonMetadata: &jsonMetadata{Version: &version, Timestamp: &ts}})
+	f.Relation(&jsonRelation{ID: 20, Type: "relation", Tags: map[string]interface{}{}, Bounds: geo.NewBound(1, 2, 3, 4), Partial: true})
+	assert.Nil(t, f.Close())
+
+	file := readFlatGeobuf(t, path)
+	assert.Equal(t, uint16(0), file.header.GetUint16Slot(fgbSlot(9), fgbNodeSize))
+	assert.Nil(t, file.index)
+	assert.Equal(t, uint8(fgbPoint), file.header.GetUint8Slot(fgbSlot(2), fgbUnknown))
+	assert.Equal(t, []string{"id", "name", "bounds.n", "partial", "version", "timestamp"}, fgbColumnNames(file.header))
+	assert.Equal(t, []float64{0, 0, 0, 0}, fgbDoubles(file.header, 1))
+
+	// without an index features are in the order they were written
+	types := []byte{fgbLong, fgbString, fgbDouble, fgbBool, fgbInt, fgbDateTime}
+	node := fgbProperties(file.features[0], types)
+	assert.Len(t, node, 4)
+	assert.Equal(t, "cafe", string(node[1]))
+	assert.Equal(t, uint32(3), binary.LittleEndian.Uint32(node[4]))
+	assert.Equal(t, "2020-01-02T03:04:05Z", string(node[5]))
+
+	var relation map[int][]byte
+	for offset, feature := range file.features {
+		if offset > 0 {
+			relation = fgbProperties(feature, types)
+		}
+	}
+	assert.Len(t, relation, 3)
+	assert.Equal(t, 4.0, math.Float64frombits(binary.LittleEndian.Uint64(relation[2])))
+	assert.Equal(t, []byte{1}, relation[3])
+}
+
+// the ends of the rings or lines of a geometry
+func fgbEnds(geometry *flatbuffers.Table) []uint32 {
+	o := fgbField(geometry, 0)
+	if o == 0 {
+		return nil
+	}
+	ends := make([]uint32, geometry.VectorLen(o))
+	for j := range ends {
+		ends[j] = geometry.GetUint32(geometry.Vector(o) + flatbuffers.UOffsetT(4*j))
+	}
+	return ends
+}
+
+// the geometries of a multi-polygon or collection
+func fgbParts(geometry *flatbuffers.Table) []*flatbuffers.Table {
+	o := fgbField(geometry, 7)
+	var parts []*flatbuffers.Table
+	for j := 0; j < geometry.VectorLen(o); j++ {
+		parts = append(parts, &flatbuffers.Table{Bytes: geometry.Bytes, Pos: geometry.Indirect(geometry.Vector(o) + flatbuffers.UOffsetT(4*j))})
+	}
+	return parts
+}
+
+func TestFlatGeobufRelationGeometry(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "records.fgb")
+	f := openFlatGeobuf(path, nil, false, dir)
+
+	outer, hole := square(0, 0, 4), square(1, 1, 1)
+	island := square(10, 10, 1)
+	line := []latLon{{Lat: 20, Lon: 20}, {Lat: 21, Lon: 21}}
+	tags := map[string]interface{}{}
+	f.Relation(&jsonRelation{ID: 20, Type: "relation", Tags: tags, Geometry: &shape{polygons: [][][]latLon{{outer, hole}}}})
+	f.Relation(&jsonRelation{ID: 21, Type: "relation", Tags: tags, Geometry: &shape{polygons: [][][]latLon{{outer, hole}, {island}}}})
+	f.Relation(&jsonRelation{ID: 22, Type: "relation", Tags: tags, Geometry: &shape{lines: [][]latLon{line, line}}})
+	f.Relation(&jsonRelation{ID: 23, Type: "relation", Tags: tags, Geometry: &shape{polygons: [][][]latLon{{island}}, lines: [][]latLon{line}}})
+	assert.Nil(t, f.Close())
+
+	file := readFlatGeobuf(t, path)
+	assert.Equal(t, uint8(fgbUnknown), file.header.GetUint8Slot(fgbSlot(2), fgbUnknown))
+	assert.Equal(t, []float64{0, 0, 21, 21}, fgbDoubles(file.header, 1))
+	var geometries []*flatbuffers.Table
+	for offset := int64(0); len(geometries) < len(file.features); {
+		feature := file.features[offset]
+		geometries = append(geometries, fgbSubTable(feature, 0))
+		offset += int64(4 + len(feature.Bytes))
+	}
+
+	// a polygon with a hole has the end of each ring
+	polygon := geometries[0]
+	assert.Equal(t, uint8(fgbPolygon), polygon.GetUint8Slot(fgbSlot(6), 0))
+	assert.Equal(t, []uint32{5, 10}, fgbEnds(polygon))
+	assert.Len(t, fgbDoubles(polygon, 1), 20)
+
+	// several polygons are the parts of a multi-polygon
+	multi := geometries[1]
+	assert.Equal(t, uint8(fgbMultiPolygon), multi.GetUint8Slot(fgbSlot(6), 0))
+	assert.Nil(t, fgbDoubles(multi, 1))
+	parts := fgbParts(multi)
+	assert.Len(t, parts, 2)
+	assert.Equal(t, []uint32{5, 10}, fgbEnds(parts[0]))
+	assert.Equal(t, []uint32{5}, fgbEnds(parts[1]))
+	assert.Equal(t, 10.0, fgbDoubles(parts[1], 1)[0])
+
+	// several lines share the coordinates, split by their ends
+	lines := geometries[2]
+	assert.Equal(t, uint8(fgbMultiLineString), lines.GetUint8Slot(fgbSlot(6), 0))
+	assert.Equal(t, []uint32{2, 4}, fgbEnds(lines))
+	assert.Equal(t, []float64{20, 20, 21, 21, 20, 20, 21, 21}, fgbDoubles(lines, 1))
+
+	// polygons and lines are a collection
+	collection := geometries[3]
+	assert.Equal(t, uint8(fgbGeometryCollection), collection.GetUint8Slot(fgbSlot(6), 0))
+	parts = fgbParts(collection)
+	assert.Len(t, parts, 2)
+	assert.Equal(t, uint8(fgbPolygon), parts[0].GetUint8Slot(fgbSlot(6), 0))
+	assert.Equal(t, uint8(fgbLineString), parts[1].GetUint8Slot(fgbSlot(6), 0))
+	assert.Nil(t, fgbEnds(parts[1]))
+}
+
+func TestFlatGeobufCloseError(t *testing.T) {
+	dir := t.TempDir()
+	f := openFlatGeobuf(filepath.Join(dir, "records.fgb"), nil, true, dir)
+	f.Node(&jsonNode{ID: 1, Type: "node", Tags: map[string]string{}})
+
+	// the spilled features can't be read back
+	f.spill.Close()
+	assert.NotNil(t, f.Close())
+	assert.Nil(t, f.Close())
+}
@@ -0,0 +1,76 @@
+package main
+
+// output formats
+const (
+	formatJSON   = "json"   // a JSON record per line
+	formatESBulk = "esbulk" // elasticsearch _bulk API action and document lines
+	formatPelias = "pelias" // a pelias document per line
+	formatGPKG   = "gpkg"   // tables in a GeoPackage (sqlite) file
+	formatFGB    = "fgb"    // features of a FlatGeobuf file
+	formatCSV    = "csv"    // comma-separated values of the selected columns
+	formatTSV    = "tsv"    // tab-separated values of the selected columns
+	formatPGCopy = "pgcopy" // rows of the PostgreSQL COPY text format
+)
+
+// recordFormat - encodes records for output, the encoded bytes are only
+// valid until the next record is encoded. Formats return nil for records
+// which they can not represent.
+type recordFormat interface {
+	Node(r *jsonNode) []byte
+	Way(r *jsonWay) []byte
+	Relation(r *jsonRelation) []byte
+	Ext() string    // extension of the files written to an output directory
+	Header() []byte // written at the start of every output file, nil for none
+}
+
+// recordOutput - where the records of a layer are printed, returns false
+// for records which the output format can not represent
+type recordOutput interface {
+	Node(r *jsonNode) bool
+	Way(r *jsonWay) bool
+	Relation(r *jsonRelation) bool
+}
+
+// encodedOutput - encodes records and writes them to a record stream
+type encodedOutput struct {
+	format recordFormat
+	w      recordWriter
+}
+
+func (o *encodedOutput) Node(r *jsonNode) bool {
+	return o.write("node", r.ID, o.format.Node(r))
+}
+
+func (o *encodedOutput) Way(r *jsonWay) bool {
+	return o.write("way", r.ID, o.format.Way(r))
+}
+
+func (o *encodedOutput) Relation(r *jsonRelation) bool {
+	return o.write("relation", r.ID, o.format.Relation(r))
+}
+
+func (o *encodedOutput) write(kind string, id int64, data []byte) bool {
+	if data == nil {
+		return false
+	}
+	o.w.WriteRecord(kind, id, data)
+	return true
+}
+
+// newRecordFormat - the encoder for the output format selected in the settings
+func newRecordFormat(config settings) recordFormat {
+	switch config.Format {
+	case formatESBulk:
+		return newESBulkEncoder(config.ESIndex, config.Precision)
+	case formatPelias:
+		return newPeliasEncoder(config.Pelias, config.Precision)
+	case formatCSV, formatTSV:
+		return newCSVEncoder(config.Format, config.Columns, config.Precision)
+	case formatPGCopy:
+		return newPGCopyEncoder(config.PGTags)
+	default:
+		e := newRecordEncoder(config.Schema, config.Precision)
+		e.geometry = config.Geometry
+		return e
+	}
+}
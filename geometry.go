@@ -0,0 +1,497 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/paulmach/go.geo"
+)
+
+// formats of the geometry field of way and relation records
+const (
+	geometryNone      = ""
+	geometryWKT       = "wkt"       // well-known text
+	geometryWKBHex    = "wkb-hex"   // hex encoded well-known binary
+	geometryPolyline6 = "polyline6" // an encoded polyline per line or ring, 6 decimal places
+	geometryGeoJSON   = "geojson"   // a GeoJSON geometry object
+)
+
+// more WKB geometry types, see wkb.go
+const (
+	wkbMultiLineString    = 5
+	wkbMultiPolygon       = 6
+	wkbGeometryCollection = 7
+)
+
+// shape - the geometry of a way or an assembled relation. Each polygon is an
+// outer ring followed by its inner rings.
+type shape struct {
+	polygons [][][]latLon
+	lines    [][]latLon
+}
+
+// wayShape - a closed way is a polygon, an open way a line
+func wayShape(latlons []latLon) *shape {
+	switch {
+	case len(latlons) < 2:
+		return nil
+	case isClosedRing(latlons):
+		return &shape{polygons: [][][]latLon{{latlons}}}
+	default:
+		return &shape{lines: [][]latLon{latlons}}
+	}
+}
+
+// bounds - the extent of the lines and outer rings of a shape
+func (s *shape) bounds() *geo.Bound {
+	west, east, south, north := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+	extend := func(latlons []latLon) {
+		for _, latlon := range latlons {
+			west, east = math.Min(west, latlon.Lon), math.Max(east, latlon.Lon)
+			south, north = math.Min(south, latlon.Lat), math.Max(north, latlon.Lat)
+		}
+	}
+	for _, polygon := range s.polygons {
+		extend(polygon[0])
+	}
+	for _, line := range s.lines {
+		extend(line)
+	}
+	return geo.NewBound(west, east, south, north)
+}
+
+// assembleShape - join the member ways of a relation which share end nodes
+// in to rings and lines. Rings are polygons, unless they are inside another
+// ring, in which case they are a hole in it (or an island in that hole).
+// note: rings are nested by location only, member roles are ignored.
+func assembleShape(ways [][]latLon) *shape {
+	rings, lines := joinWays(ways)
+	if len(rings) == 0 && len(lines) == 0 {
+		return nil
+	}
+
+	// larger rings first, so the last ring containing another is its parent
+	areas := make([]float64, len(rings))
+	for i, ring := range rings {
+		areas[i] = math.Abs(ringArea(ring))
+	}
+	sort.Sort(byArea{rings, areas})
+
+	s := &shape{lines: lines}
+	type placedRing struct {
+		ring    []latLon
+		bounds  [4]float64
+		depth   int
+		polygon int // the polygon the ring is the outer or an inner ring of
+	}
+	placed := make([]placedRing, 0, len(rings))
+	for _, ring := range rings {
+		r := placedRing{ring: ring, bounds: ringBounds(ring)}
+		point := midpoint(ring[0], ring[1])
+		for i := len(placed) - 1; i >= 0; i-- {
+			p := &placed[i]
+			if boundsContain(p.bounds, r.bounds) && pointInRing(point, p.ring) {
+				r.depth, r.polygon = p.depth+1, p.polygon
+				break
+			}
+		}
+		if r.depth%2 == 0 {
+			r.polygon = len(s.polygons)
+			s.polygons = append(s.polygons, [][]latLon{ring})
+		} else {
+			s.polygons[r.polygon] = append(s.polygons[r.polygon], ring)
+		}
+		placed = append(placed, r)
+	}
+	return s
+}
+
+// joinWays - join ways end to end, closed ways are rings and ways which
+// can't be closed are lines
+func joinWays(ways [][]latLon) (rings [][]latLon, lines [][]latLon) {
+	var open [][]latLon
+	ends := make(map[[2]float64][]int) // the open ways ending at each location
+	for _, way := range ways {
+		switch {
+		case len(way) < 2:
+		case isClosedRing(way):
+			rings = append(rings, way)
+		default:
+			ends[location(way[0])] = append(ends[location(way[0])], len(open))
+			ends[location(way[len(way)-1])] = append(ends[location(way[len(way)-1])], len(open))
+			open = append(open, way)
+		}
+	}
+
+	used := make([]bool, len(open))
+	next := func(end latLon) int {
+		for _, i := range ends[location(end)] {
+			if !used[i] {
+				used[i] = true
+				return i
+			}
+		}
+		return -1
+	}
+	for i, way := range open {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		line := append([]latLon(nil), way...)
+
+		// extend the end of the line, then the start of it
+		line = extendLine(line, open, next)
+		if location(line[0]) != location(line[len(line)-1]) {
+			reverseLatLons(line)
+			line = extendLine(line, open, next)
+			reverseLatLons(line)
+		}
+
+		if isClosedRing(line) {
+			rings = append(rings, line)
+		} else {
+			lines = append(lines, line)
+		}
+	}
+	return rings, lines
+}
+
+// append the ways which continue on from the end of a line, until it is
+// closed or none do. next returns an unused way ending at a location, or -1.
+func extendLine(line []latLon, ways [][]latLon, next func(end latLon) int) []latLon {
+	for {
+		end := line[len(line)-1]
+		if location(line[0]) == location(end) {
+			return line
+		}
+		i := next(end)
+		if i < 0 {
+			return line
+		}
+		if location(ways[i][0]) == location(end) {
+			line = append(line, ways[i][1:]...)
+			continue
+		}
+		for k := len(ways[i]) - 2; k >= 0; k-- {
+			line = append(line, ways[i][k])
+		}
+	}
+}
+
+func location(latlon latLon) [2]float64 {
+	return [2]float64{latlon.Lat, latlon.Lon}
+}
+
+func reverseLatLons(latlons []latLon) {
+	for i, j := 0, len(latlons)-1; i < j; i, j = i+1, j-1 {
+		latlons[i], latlons[j] = latlons[j], latlons[i]
+	}
+}
+
+func midpoint(a latLon, b latLon) latLon {
+	return latLon{Lat: (a.Lat + b.Lat) / 2, Lon: (a.Lon + b.Lon) / 2}
+}
+
+// the signed area of a ring in square degrees, positive when counter-clockwise
+func ringArea(ring []latLon) float64 {
+	var area float64
+	for i := 1; i < len(ring); i++ {
+		area += ring[i-1].Lon*ring[i].Lat - ring[i].Lon*ring[i-1].Lat
+	}
+	return area / 2
+}
+
+// west, south, east and north
+func ringBounds(ring []latLon) [4]float64 {
+	b := [4]float64{ring[0].Lon, ring[0].Lat, ring[0].Lon, ring[0].Lat}
+	for _, p := range ring[1:] {
+		b[0], b[1] = math.Min(b[0], p.Lon), math.Min(b[1], p.Lat)
+		b[2], b[3] = math.Max(b[2], p.Lon), math.Max(b[3], p.Lat)
+	}
+	return b
+}
+
+func boundsContain(outer [4]float64, inner [4]float64) bool {
+	return outer[0] <= inner[0] && outer[1] <= inner[1] && outer[2] >= inner[2] && outer[3] >= inner[3]
+}
+
+// pointInRing - whether a point is inside a ring, by counting the edges a ray
+// to the east of it crosses
+func pointInRing(p latLon, ring []latLon) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) && p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// byArea - sorts rings by decreasing area
+type byArea struct {
+	rings [][]latLon
+	areas []float64
+}
+
+func (s byArea) Len() int           { return len(s.rings) }
+func (s byArea) Less(i, j int) bool { return s.areas[i] > s.areas[j] }
+func (s byArea) Swap(i, j int) {
+	s.rings[i], s.rings[j] = s.rings[j], s.rings[i]
+	s.areas[i], s.areas[j] = s.areas[j], s.areas[i]
+}
+
+// append the geometry field value in the selected format
+func (e *recordEncoder) appendGeometry(b []byte, s *shape) []byte {
+	switch e.geometry {
+	case geometryWKT:
+		b = append(b, '"')
+		b = e.appendWKT(b, s)
+		return append(b, '"')
+	case geometryWKBHex:
+		e.geom = appendWKBShape(e.geom[:0], s, 0)
+		b = append(b, '"')
+		b = appendHex(b, e.geom)
+		return append(b, '"')
+	case geometryPolyline6:
+		b = append(b, '[')
+		for _, polygon := range s.polygons {
+			for _, ring := range polygon {
+				b = appendPolyline(b, ring)
+				b = append(b, ',')
+			}
+		}
+		for _, line := range s.lines {
+			b = appendPolyline(b, line)
+			b = append(b, ',')
+		}
+		return append(b[:len(b)-1], ']')
+	default:
+		return e.appendGeoJSON(b, s)
+	}
+}
+
+// append a shape as WKT, a single polygon or line unless there are several
+func (e *recordEncoder) appendWKT(b []byte, s *shape) []byte {
+	if len(s.polygons) > 0 && len(s.lines) > 0 {
+		b = append(b, "GEOMETRYCOLLECTION ("...)
+		b = e.appendWKT(b, &shape{polygons: s.polygons})
+		b = append(b, ", "...)
+		b = e.appendWKT(b, &shape{lines: s.lines})
+		return append(b, ')')
+	}
+	switch {
+	case len(s.polygons) == 1:
+		b = append(b, "POLYGON "...)
+		return e.appendWKTPolygon(b, s.polygons[0])
+	case len(s.polygons) > 1:
+		b = append(b, "MULTIPOLYGON ("...)
+		for i, polygon := range s.polygons {
+			if i > 0 {
+				b = append(b, ", "...)
+			}
+			b = e.appendWKTPolygon(b, polygon)
+		}
+		return append(b, ')')
+	case len(s.lines) == 1:
+		b = append(b, "LINESTRING "...)
+		return e.appendWKTLine(b, s.lines[0])
+	default:
+		b = append(b, "MULTILINESTRING ("...)
+		for i, line := range s.lines {
+			if i > 0 {
+				b = append(b, ", "...)
+			}
+			b = e.appendWKTLine(b, line)
+		}
+		return append(b, ')')
+	}
+}
+
+func (e *recordEncoder) appendWKTPolygon(b []byte, rings [][]latLon) []byte {
+	b = append(b, '(')
+	for i, ring := range rings {
+		if i > 0 {
+			b = append(b, ", "...)
+		}
+		b = e.appendWKTLine(b, ring)
+	}
+	return append(b, ')')
+}
+
+func (e *recordEncoder) appendWKTLine(b []byte, latlons []latLon) []byte {
+	b = append(b, '(')
+	for i, latlon := range latlons {
+		if i > 0 {
+			b = append(b, ", "...)
+		}
+		b = e.appendWKTNumber(b, latlon.Lon)
+		b = append(b, ' ')
+		b = e.appendWKTNumber(b, latlon.Lat)
+	}
+	return append(b, ')')
+}
+
+// append a WKT coordinate, in v1 it is printed at full precision without an exponent
+func (e *recordEncoder) appendWKTNumber(b []byte, f float64) []byte {
+	if e.schema == schemaV2 {
+		return appendRounded(b, f, e.precision)
+	}
+	return strconv.AppendFloat(b, f, 'f', -1, 64)
+}
+
+// append a shape as a GeoJSON geometry object
+func (e *recordEncoder) appendGeoJSON(b []byte, s *shape) []byte {
+	if len(s.polygons) > 0 && len(s.lines) > 0 {
+		b = append(b, `{"type":"GeometryCollection","geometries":[`...)
+		b = e.appendGeoJSON(b, &shape{polygons: s.polygons})
+		b = append(b, ',')
+		b = e.appendGeoJSON(b, &shape{lines: s.lines})
+		return append(b, "]}"...)
+	}
+	switch {
+	case len(s.polygons) == 1:
+		b = append(b, `{"type":"Polygon","coordinates":`...)
+		b = e.appendGeoJSONPolygon(b, s.polygons[0])
+	case len(s.polygons) > 1:
+		b = append(b, `{"type":"MultiPolygon","coordinates":[`...)
+		for i, polygon := range s.polygons {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = e.appendGeoJSONPolygon(b, polygon)
+		}
+		b = append(b, ']')
+	case len(s.lines) == 1:
+		b = append(b, `{"type":"LineString","coordinates":`...)
+		b = e.appendGeoJSONLine(b, s.lines[0])
+	default:
+		b = append(b, `{"type":"MultiLineString","coordinates":[`...)
+		for i, line := range s.lines {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = e.appendGeoJSONLine(b, line)
+		}
+		b = append(b, ']')
+	}
+	return append(b, '}')
+}
+
+func (e *recordEncoder) appendGeoJSONPolygon(b []byte, rings [][]latLon) []byte {
+	b = append(b, '[')
+	for i, ring := range rings {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = e.appendGeoJSONLine(b, ring)
+	}
+	return append(b, ']')
+}
+
+func (e *recordEncoder) appendGeoJSONLine(b []byte, latlons []latLon) []byte {
+	b = append(b, '[')
+	for i, latlon := range latlons {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, '[')
+		b = e.appendNumber(b, latlon.Lon)
+		b = append(b, ',')
+		b = e.appendNumber(b, latlon.Lat)
+		b = append(b, ']')
+	}
+	return append(b, ']')
+}
+
+// append a shape as WKB, a single polygon or line unless there are several.
+// note: only the outer geometry of EWKB has an srid, 0 for plain WKB
+func appendWKBShape(b []byte, s *shape, srid uint32) []byte {
+	if len(s.polygons) > 0 && len(s.lines) > 0 {
+		b = appendWKBHeader(b, wkbGeometryCollection, srid)
+		b = appendUint32(b, 2)
+		b = appendWKBShape(b, &shape{polygons: s.polygons}, 0)
+		return appendWKBShape(b, &shape{lines: s.lines}, 0)
+	}
+	switch {
+	case len(s.polygons) == 1:
+		return appendWKBPolygon(b, s.polygons[0], srid)
+	case len(s.polygons) > 1:
+		b = appendWKBHeader(b, wkbMultiPolygon, srid)
+		b = appendUint32(b, uint32(len(s.polygons)))
+		for _, polygon := range s.polygons {
+			b = appendWKBPolygon(b, polygon, 0)
+		}
+		return b
+	case len(s.lines) == 1:
+		return appendWKBLine(b, s.lines[0], srid)
+	default:
+		b = appendWKBHeader(b, wkbMultiLineString, srid)
+		b = appendUint32(b, uint32(len(s.lines)))
+		for _, line := range s.lines {
+			b = appendWKBLine(b, line, 0)
+		}
+		return b
+	}
+}
+
+func appendWKBPolygon(b []byte, rings [][]latLon, srid uint32) []byte {
+	b = appendWKBHeader(b, wkbPolygon, srid)
+	b = appendUint32(b, uint32(len(rings)))
+	for _, ring := range rings {
+		b = appendWKBPoints(b, ring)
+	}
+	return b
+}
+
+func appendWKBLine(b []byte, latlons []latLon, srid uint32) []byte {
+	return appendWKBPoints(appendWKBHeader(b, wkbLineString, srid), latlons)
+}
+
+// append the number of points and their coordinates
+func appendWKBPoints(b []byte, latlons []latLon) []byte {
+	b = appendUint32(b, uint32(len(latlons)))
+	for _, latlon := range latlons {
+		b = appendFloat64(b, latlon.Lon)
+		b = appendFloat64(b, latlon.Lat)
+	}
+	return b
+}
+
+// append a quoted encoded polyline with 6 decimal places, lat before lon
+// see https://developers.google.com/maps/documentation/utilities/polylinealgorithm
+func appendPolyline(b []byte, latlons []latLon) []byte {
+	b = append(b, '"')
+	var lat, lon int64
+	for _, latlon := range latlons {
+		y, x := int64(math.Round(latlon.Lat*1e6)), int64(math.Round(latlon.Lon*1e6))
+		b = appendPolylineValue(b, y-lat)
+		b = appendPolylineValue(b, x-lon)
+		lat, lon = y, x
+	}
+	return append(b, '"')
+}
+
+// append a zig-zag encoded value in 5 bit chunks, '\' is the only character
+// of the encoding which has to be escaped in a JSON string
+func appendPolylineValue(b []byte, v int64) []byte {
+	u := uint64(v) << 1
+	if v < 0 {
+		u = ^u
+	}
+	for {
+		c := byte(u&0x1f) + 63
+		if u >>= 5; u > 0 {
+			c += 0x20
+		}
+		if c == '\\' {
+			b = append(b, '\\')
+		}
+		b = append(b, c)
+		if u == 0 {
+			return b
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/paulmach/go.geo"
+	"github.com/stretchr/testify/assert"
+)
+
+// a square ring counter-clockwise from the south west corner
+func square(west float64, south float64, size float64) []latLon {
+	return []latLon{{Lat: south, Lon: west}, {Lat: south, Lon: west + size}, {Lat: south + size, Lon: west + size}, {Lat: south + size, Lon: west}, {Lat: south, Lon: west}}
+}
+
+func TestWayShape(t *testing.T) {
+	ring := square(0, 0, 1)
+	assert.Equal(t, &shape{polygons: [][][]latLon{{ring}}}, wayShape(ring))
+	assert.Equal(t, &shape{lines: [][]latLon{ring[:3]}}, wayShape(ring[:3]))
+	assert.Nil(t, wayShape(ring[:1]))
+}
+
+func TestAssembleShape(t *testing.T) {
+	outer, hole, island := square(0, 0, 10), square(2, 2, 6), square(4, 4, 2)
+	line := []latLon{{Lat: 20, Lon: 20}, {Lat: 21, Lon: 21}}
+
+	// the outer ring is split in two, with the second half reversed
+	second := append([]latLon(nil), outer[2:]...)
+	reverseLatLons(second)
+	s := assembleShape([][]latLon{island, outer[:3], line, hole, second})
+	assert.Equal(t, [][]latLon{line}, s.lines)
+	assert.Len(t, s.polygons, 2)
+	assert.Equal(t, outer, s.polygons[0][0])
+	assert.Equal(t, [][]latLon{hole}, s.polygons[0][1:])
+	assert.Equal(t, [][]latLon{island}, s.polygons[1])
+
+	// ways which can't be closed are lines
+	s = assembleShape([][]latLon{outer[:2], outer[2:4]})
+	assert.Empty(t, s.polygons)
+	assert.Len(t, s.lines, 2)
+
+	assert.Nil(t, assembleShape([][]latLon{outer[:1]}))
+}
+
+func TestGeometryWKT(t *testing.T) {
+	e := newRecordEncoder(schemaV2, 1)
+	e.geometry = geometryWKT
+	line := []latLon{{Lat: 1.25, Lon: -2}, {Lat: 3, Lon: 4}}
+	assert.Equal(t, `"LINESTRING (-2 1.2, 4 3)"`, string(e.appendGeometry(nil, &shape{lines: [][]latLon{line}})))
+
+	e = newRecordEncoder(schemaV1, defaultPrecision)
+	e.geometry = geometryWKT
+	polygon := [][]latLon{square(0, 0, 1), square(0.25, 0.25, 0.5)}
+	assert.Equal(t, `"POLYGON ((0 0, 1 0, 1 1, 0 1, 0 0), (0.25 0.25, 0.75 0.25, 0.75 0.75, 0.25 0.75, 0.25 0.25))"`, string(e.appendGeometry(nil, &shape{polygons: [][][]latLon{polygon}})))
+	assert.Equal(t, `"MULTILINESTRING ((-2 1.25, 4 3), (-2 1.25, 4 3))"`, string(e.appendGeometry(nil, &shape{lines: [][]latLon{line, line}})))
+	assert.Equal(t, `"GEOMETRYCOLLECTION (MULTIPOLYGON (((0 0, 1 0, 1 1, 0 1, 0 0)), ((2 2, 3 2, 3 3, 2 3, 2 2))), LINESTRING (-2 1.25, 4 3))"`,
+		string(e.appendGeometry(nil, &shape{polygons: [][][]latLon{{square(0, 0, 1)}, {square(2, 2, 1)}}, lines: [][]latLon{line}})))
+
+	// no exponent for small values
+	assert.Equal(t, `"LINESTRING (0.0000001 0, 0 0)"`, string(e.appendGeometry(nil, &shape{lines: [][]latLon{{{Lon: 1e-7}, {}}}})))
+}
+
+func TestGeometryGeoJSON(t *testing.T) {
+	e := newRecordEncoder(schemaV1, defaultPrecision)
+	e.geometry = geometryGeoJSON
+	s := &shape{polygons: [][][]latLon{{square(0, 0, 1)}}, lines: [][]latLon{{{Lat: 1.5, Lon: -2}, {Lat: 3, Lon: 4}}}}
+
+	var geometry struct {
+		Type       string
+		Geometries []struct {
+			Type        string
+			Coordinates json.RawMessage
+		}
+	}
+	assert.Nil(t, json.Unmarshal(e.appendGeometry(nil, s), &geometry))
+	assert.Equal(t, "GeometryCollection", geometry.Type)
+	assert.Equal(t, "Polygon", geometry.Geometries[0].Type)
+	assert.Equal(t, "[[[0,0],[1,0],[1,1],[0,1],[0,0]]]", string(geometry.Geometries[0].Coordinates))
+	assert.Equal(t, "LineString", geometry.Geometries[1].Type)
+	assert.Equal(t, "[[-2,1.5],[4,3]]", string(geometry.Geometries[1].Coordinates))
+}
+
+func TestGeometryWKBHex(t *testing.T) {
+	e := newRecordEncoder(schemaV1, defaultPrecision)
+	e.geometry = geometryWKBHex
+	s := &shape{polygons: [][][]latLon{{square(0, 0, 1)}, {square(2, 2, 1)}}}
+
+	var value string
+	assert.Nil(t, json.Unmarshal(e.appendGeometry(nil, s), &value))
+	b, err := hex.DecodeString(value)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(wkbMultiPolygon), binary.LittleEndian.Uint32(b[1:]))
+	assert.Equal(t, uint32(2), binary.LittleEndian.Uint32(b[5:]))
+	assert.Equal(t, appendWKBWay(nil, square(0, 0, 1), 0), b[9:9+len(b[9:])/2])
+}
+
+func TestGeometryPolyline6(t *testing.T) {
+	e := newRecordEncoder(schemaV1, defaultPrecision)
+	e.geometry = geometryPolyline6
+
+	// the example of the polyline algorithm, which has 5 decimal places
+	line := []latLon{{Lat: 3.85, Lon: -12.02}, {Lat: 4.07, Lon: -12.095}, {Lat: 4.3252, Lon: -12.6453}}
+	s := &shape{polygons: [][][]latLon{{square(0, 0, 1)}}, lines: [][]latLon{line}}
+	var values []string
+	assert.Nil(t, json.Unmarshal(e.appendGeometry(nil, s), &values))
+	assert.Len(t, values, 2)
+	assert.Equal(t, "_p~iF~ps|U_ulLnnqC_mqNvxq`@", values[1])
+
+	// backslashes are escaped
+	assert.Equal(t, `"\\?"`, string(appendPolyline(nil, []latLon{{Lat: -0.000015}})))
+}
+
+func TestEncodeGeometry(t *testing.T) {
+	e := newRecordEncoder(schemaV2, 7)
+	e.geometry = geometryWKT
+	nodes := []latLon{{Lat: 1, Lon: 2}, {Lat: 3, Lon: 4}}
+	way := jsonWay{ID: 1, Type: "way", Tags: map[string]string{}, Bounds: geo.NewBound(2, 4, 1, 3), Geometry: wayShape(nodes), Nodes: nodes}
+	assert.Equal(t, `{"id":1,"type":"way","tags":{},"centroid":{"lat":0,"lon":0},"bounds":[2,1,4,3],"geometry":"LINESTRING (2 1, 4 3)","nodes":[{"lat":1,"lon":2},{"lat":3,"lon":4}]}`, string(e.Way(&way)))
+
+	relation := jsonRelation{ID: 2, Type: "relation", Tags: map[string]interface{}{}, Bounds: geo.NewBound(2, 4, 1, 3), Geometry: assembleShape([][]latLon{nodes}), Partial: true}
+	assert.Equal(t, `{"id":2,"type":"relation","tags":{},"centroid":{"lat":0,"lon":0},"bounds":[2,1,4,3],"geometry":"LINESTRING (2 1, 4 3)","partial":true}`, string(e.Relation(&relation)))
+}
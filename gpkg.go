@@ -0,0 +1,476 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/paulmach/go.geo"
+	"modernc.org/sqlite" // a pure Go driver, the build doesn't require cgo
+)
+
+// GeoPackage constants, see http://www.geopackage.org/spec130/
+const (
+	gpkgApplicationID = 0x47504B47 // 'GPKG'
+	gpkgUserVersion   = 10300      // version 1.3.0
+	gpkgSRS           = 4326       // WGS 84 longitude/latitude
+	gpkgBatchSize     = 100000     // rows inserted per transaction
+	gpkgInsertRows    = 10         // rows inserted per statement
+	gpkgTimestamp     = "2006-01-02T15:04:05.000Z"
+)
+
+// the feature tables, one per element type
+var gpkgTables = []string{"nodes", "ways", "relations"}
+
+const gpkgSchema = `
+CREATE TABLE gpkg_spatial_ref_sys (
+	srs_name TEXT NOT NULL,
+	srs_id INTEGER PRIMARY KEY,
+	organization TEXT NOT NULL,
+	organization_coordsys_id INTEGER NOT NULL,
+	definition TEXT NOT NULL,
+	description TEXT
+);
+CREATE TABLE gpkg_contents (
+	table_name TEXT NOT NULL PRIMARY KEY,
+	data_type TEXT NOT NULL,
+	identifier TEXT UNIQUE,
+	description TEXT DEFAULT '',
+	last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+	min_x DOUBLE,
+	min_y DOUBLE,
+	max_x DOUBLE,
+	max_y DOUBLE,
+	srs_id INTEGER REFERENCES gpkg_spatial_ref_sys(srs_id)
+);
+CREATE TABLE gpkg_geometry_columns (
+	table_name TEXT NOT NULL UNIQUE REFERENCES gpkg_contents(table_name),
+	column_name TEXT NOT NULL,
+	geometry_type_name TEXT NOT NULL,
+	srs_id INTEGER NOT NULL REFERENCES gpkg_spatial_ref_sys(srs_id),
+	z TINYINT NOT NULL,
+	m TINYINT NOT NULL,
+	PRIMARY KEY (table_name, column_name)
+);
+CREATE TABLE gpkg_extensions (
+	table_name TEXT,
+	column_name TEXT,
+	extension_name TEXT NOT NULL,
+	definition TEXT NOT NULL,
+	scope TEXT NOT NULL,
+	UNIQUE (table_name, column_name, extension_name)
+);
+INSERT INTO gpkg_spatial_ref_sys VALUES
+	('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', 'undefined cartesian coordinate reference system'),
+	('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', 'undefined geographic coordinate reference system'),
+	('WGS 84 geodetic', 4326, 'EPSG', 4326, 'GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]]', 'longitude/latitude coordinates in decimal degrees on the WGS 84 spheroid');
+`
+
+// every feature table has the same columns, the bounds are null for nodes
+const gpkgTableSchema = `
+CREATE TABLE %[1]s (
+	fid INTEGER PRIMARY KEY AUTOINCREMENT,
+	geom %[2]s,
+	id INTEGER NOT NULL,
+	layer TEXT,
+	tags TEXT NOT NULL,
+	lat DOUBLE NOT NULL,
+	lon DOUBLE NOT NULL,
+	centroid_type TEXT,
+	min_lon DOUBLE,
+	min_lat DOUBLE,
+	max_lon DOUBLE,
+	max_lat DOUBLE,
+	partial BOOLEAN,
+	version INTEGER,
+	timestamp DATETIME,
+	changeset INTEGER,
+	uid INTEGER,
+	user TEXT,
+	visible BOOLEAN
+);
+INSERT INTO gpkg_contents (table_name, data_type, identifier, srs_id) VALUES ('%[1]s', 'features', '%[1]s', 4326);
+INSERT INTO gpkg_geometry_columns VALUES ('%[1]s', 'geom', '%[2]s', 4326, 0, 0);
+`
+
+const gpkgInsert = `INSERT INTO %s (geom, id, layer, tags, lat, lon, centroid_type, min_lon, min_lat, max_lon, max_lat, partial,
+	version, timestamp, changeset, uid, user, visible) VALUES `
+
+const gpkgInsertValues = "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+// the spatial index is built from the geometries once all rows are written,
+// then the triggers of the rtree extension are created to maintain it when the
+// table is edited.
+// note: the triggers call the spatial SQL functions GeoPackage clients such
+// as GDAL provide, an edit without them fails rather than leaving the index stale.
+const gpkgIndex = `
+CREATE INDEX %[1]s_id ON %[1]s (id);
+CREATE VIRTUAL TABLE rtree_%[1]s_geom USING rtree(id, minx, maxx, miny, maxy);
+INSERT INTO rtree_%[1]s_geom SELECT fid, ST_MinX(geom), ST_MaxX(geom), ST_MinY(geom), ST_MaxY(geom) FROM %[1]s WHERE geom NOT NULL AND NOT ST_IsEmpty(geom);
+INSERT INTO gpkg_extensions VALUES ('%[1]s', 'geom', 'gpkg_rtree_index', 'http://www.geopackage.org/spec130/#extension_rtree', 'write-only');
+UPDATE gpkg_contents SET
+	min_x = (SELECT min(ST_MinX(geom)) FROM %[1]s),
+	min_y = (SELECT min(ST_MinY(geom)) FROM %[1]s),
+	max_x = (SELECT max(ST_MaxX(geom)) FROM %[1]s),
+	max_y = (SELECT max(ST_MaxY(geom)) FROM %[1]s),
+	last_change = strftime('%%Y-%%m-%%dT%%H:%%M:%%fZ','now')
+WHERE table_name = '%[1]s';
+CREATE TRIGGER rtree_%[1]s_geom_insert AFTER INSERT ON %[1]s
+	WHEN (new.geom NOT NULL AND NOT ST_IsEmpty(NEW.geom))
+BEGIN
+	INSERT OR REPLACE INTO rtree_%[1]s_geom VALUES (NEW.fid, ST_MinX(NEW.geom), ST_MaxX(NEW.geom), ST_MinY(NEW.geom), ST_MaxY(NEW.geom));
+END;
+CREATE TRIGGER rtree_%[1]s_geom_update1 AFTER UPDATE OF geom ON %[1]s
+	WHEN OLD.fid = NEW.fid AND (NEW.geom NOTNULL AND NOT ST_IsEmpty(NEW.geom))
+BEGIN
+	INSERT OR REPLACE INTO rtree_%[1]s_geom VALUES (NEW.fid, ST_MinX(NEW.geom), ST_MaxX(NEW.geom), ST_MinY(NEW.geom), ST_MaxY(NEW.geom));
+END;
+CREATE TRIGGER rtree_%[1]s_geom_update2 AFTER UPDATE OF geom ON %[1]s
+	WHEN OLD.fid = NEW.fid AND (NEW.geom ISNULL OR ST_IsEmpty(NEW.geom))
+BEGIN
+	DELETE FROM rtree_%[1]s_geom WHERE id = OLD.fid;
+END;
+CREATE TRIGGER rtree_%[1]s_geom_update3 AFTER UPDATE ON %[1]s
+	WHEN OLD.fid != NEW.fid AND (NEW.geom NOTNULL AND NOT ST_IsEmpty(NEW.geom))
+BEGIN
+	DELETE FROM rtree_%[1]s_geom WHERE id = OLD.fid;
+	INSERT OR REPLACE INTO rtree_%[1]s_geom VALUES (NEW.fid, ST_MinX(NEW.geom), ST_MaxX(NEW.geom), ST_MinY(NEW.geom), ST_MaxY(NEW.geom));
+END;
+CREATE TRIGGER rtree_%[1]s_geom_update4 AFTER UPDATE ON %[1]s
+	WHEN OLD.fid != NEW.fid AND (NEW.geom ISNULL OR ST_IsEmpty(NEW.geom))
+BEGIN
+	DELETE FROM rtree_%[1]s_geom WHERE id IN (OLD.fid, NEW.fid);
+END;
+CREATE TRIGGER rtree_%[1]s_geom_delete AFTER DELETE ON %[1]s
+	WHEN old.geom NOT NULL
+BEGIN
+	DELETE FROM rtree_%[1]s_geom WHERE id = OLD.fid;
+END;
+`
+
+// the envelope functions of a GeoPackage geometry, used to build the index and
+// by its triggers. They are registered for every connection of the driver.
+func init() {
+	for i, name := range []string{"ST_MinX", "ST_MaxX", "ST_MinY", "ST_MaxY"} {
+		i := i
+		sqlite.MustRegisterDeterministicScalarFunction(name, 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			if envelope, empty, ok := gpkgEnvelope(args[0]); ok && !empty {
+				return envelope[i], nil
+			}
+			return nil, nil
+		})
+	}
+	sqlite.MustRegisterDeterministicScalarFunction("ST_IsEmpty", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if _, empty, ok := gpkgEnvelope(args[0]); ok {
+			return empty, nil
+		}
+		return nil, nil
+	})
+}
+
+// geoPackage - writes records to the feature tables of a GeoPackage, which
+// is also a plain sqlite database. Nodes are stored as points, ways and
+// relations with node lat/lons as their geometry, otherwise as their centroid.
+// note: it is not safe for concurrent use, records are written and the
+// package closed on the main goroutine, Close runs once the print pass stops.
+type geoPackage struct {
+	path    string
+	db      *sql.DB
+	tx      *sql.Tx
+	pending map[string][][]interface{} // the rows of each table not yet inserted
+	rows    int                        // inserted in the current transaction
+	json    *recordEncoder             // encodes the tags
+	geom    []byte
+	file    *outputFile // the rows inserted, sized once closed
+}
+
+// openGeoPackages - open a GeoPackage for the output path of each layer,
+// layers which don't specify an output path are written to path. Packages are
+// closed and indexed by the cleanup handlers on exit. The files are returned.
+func openGeoPackages(profiles []*profile, path string, wayNodes bool) []*outputFile {
+	var files []*outputFile
+	packages := make(map[string]*geoPackage)
+	for _, p := range profiles {
+		for _, l := range p.Layers {
+			output := l.Output
+			if output == "" {
+				output = path
+			}
+			if _, ok := packages[output]; !ok {
+				packages[output] = openGeoPackage(output, wayNodes)
+				addCleanup(packages[output].Close)
+				files = append(files, packages[output].file)
+			}
+			l.out = packages[output]
+		}
+	}
+	return files
+}
+
+// openGeoPackage - create a GeoPackage at path, replacing any existing file
+func openGeoPackage(path string, wayNodes bool) *geoPackage {
+	if info, err := os.Stat(path); err == nil && !info.Mode().IsRegular() {
+		fatalf("[error] %s is not a regular file, refusing to replace it with a GeoPackage", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fatal(err)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		fatal(err)
+	}
+
+	// pragmas apply to a connection, the file is incomplete until closed anyway
+	db.SetMaxOpenConns(1)
+	pkg := &geoPackage{path: path, db: db, pending: make(map[string][][]interface{}), json: newRecordEncoder(schemaV1, defaultPrecision), file: &outputFile{Path: path}}
+	queries := []string{
+		fmt.Sprintf("PRAGMA application_id = %d; PRAGMA user_version = %d;", gpkgApplicationID, gpkgUserVersion),
+		"PRAGMA journal_mode = OFF; PRAGMA synchronous = OFF; PRAGMA cache_size = -262144;",
+		gpkgSchema,
+	}
+	for _, table := range gpkgTables {
+		geometry := "POINT"
+		if table != "nodes" && wayNodes {
+			geometry = "GEOMETRY"
+		}
+		queries = append(queries, fmt.Sprintf(gpkgTableSchema, table, geometry))
+	}
+	for _, query := range queries {
+		if err := pkg.exec(query); err != nil {
+			fatal(err)
+		}
+	}
+	return pkg
+}
+
+func (p *geoPackage) exec(query string) error {
+	if _, err := p.db.Exec(query); err != nil {
+		return fmt.Errorf("%s: %v", p.path, err)
+	}
+	return nil
+}
+
+// Node - insert a node as a point
+func (p *geoPackage) Node(r *jsonNode) bool {
+	p.geom = appendGPKGPoint(p.geom[:0], r.Lon, r.Lat)
+	p.insert("nodes", r.ID, r.Layer, r.Tags, jsonCentroid{Lat: r.Lat, Lon: r.Lon}, nil, nil, r.jsonMetadata)
+	return true
+}
+
+// Way - insert a way, as a line or polygon if its nodes are available
+func (p *geoPackage) Way(r *jsonWay) bool {
+	if len(r.Nodes) > 1 {
+		p.geom = appendGPKGWay(p.geom[:0], r.Nodes, r.Bounds)
+	} else {
+		p.geom = appendGPKGPoint(p.geom[:0], r.Centroid.Lon, r.Centroid.Lat)
+	}
+	p.insert("ways", r.ID, r.Layer, r.Tags, r.Centroid, r.Bounds, r.Partial, r.jsonMetadata)
+	return true
+}
+
+// Relation - insert a relation, as the geometry assembled from its member ways if available
+func (p *geoPackage) Relation(r *jsonRelation) bool {
+	if r.Geometry != nil {
+		p.geom = appendWKBShape(appendGPKGHeader(p.geom[:0], r.Geometry.bounds()), r.Geometry, 0)
+	} else {
+		p.geom = appendGPKGPoint(p.geom[:0], r.Centroid.Lon, r.Centroid.Lat)
+	}
+	p.insert("relations", r.ID, r.Layer, r.Tags, r.Centroid, r.Bounds, r.Partial, r.jsonMetadata)
+	return true
+}
+
+// insert a row, rows are inserted several at a time in large transactions
+// note: the driver parses the SQL of every statement executed, even if it
+// was prepared, so single row inserts are much slower.
+func (p *geoPackage) insert(table string, id int64, layer string, tags interface{}, centroid jsonCentroid, bounds *geo.Bound, partial interface{}, meta *jsonMetadata) {
+	row := []interface{}{append([]byte(nil), p.geom...), id, nullString(layer), string(p.json.appendTags(nil, tags)), centroid.Lat, centroid.Lon, nullString(centroid.Type)}
+	if bounds != nil {
+		row = append(row, bounds.West(), bounds.South(), bounds.East(), bounds.North())
+	} else {
+		row = append(row, nil, nil, nil, nil)
+	}
+	row = append(row, partial)
+	if meta == nil {
+		meta = &jsonMetadata{}
+	}
+	var timestamp interface{}
+	if meta.Timestamp != nil {
+		timestamp = meta.Timestamp.UTC().Format(gpkgTimestamp)
+	}
+	row = append(row, meta.Version, timestamp, meta.Changeset, meta.UID, meta.User, meta.Visible)
+
+	p.pending[table] = append(p.pending[table], row)
+	p.file.Records++
+	if len(p.pending[table]) >= gpkgInsertRows {
+		if err := p.flush(table); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// insert the pending rows of a table, the transaction is committed once it is large enough
+func (p *geoPackage) flush(table string) error {
+	rows := p.pending[table]
+	if len(rows) == 0 {
+		return nil
+	}
+	if p.tx == nil {
+		tx, err := p.db.Begin()
+		if err != nil {
+			return fmt.Errorf("%s: %v", p.path, err)
+		}
+		p.tx = tx
+	}
+
+	query := fmt.Sprintf(gpkgInsert, table) + gpkgInsertValues + strings.Repeat(", "+gpkgInsertValues, len(rows)-1)
+	args := make([]interface{}, 0, len(rows)*len(rows[0]))
+	for _, row := range rows {
+		args = append(args, row...)
+	}
+	if _, err := p.tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("%s: %v", p.path, err)
+	}
+	p.pending[table] = rows[:0]
+
+	if p.rows += len(rows); p.rows >= gpkgBatchSize {
+		return p.commit()
+	}
+	return nil
+}
+
+func (p *geoPackage) commit() error {
+	if p.tx == nil {
+		return nil
+	}
+	err := p.tx.Commit()
+	p.tx = nil
+	p.rows = 0
+	if err != nil {
+		return fmt.Errorf("%s: %v", p.path, err)
+	}
+	return nil
+}
+
+// Close - commit the rows written, build the spatial indexes and close the database
+func (p *geoPackage) Close() error {
+	if p.db == nil {
+		return nil
+	}
+	start := time.Now()
+	err := p.finish()
+	if cerr := p.db.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("%s: %v", p.path, cerr)
+	}
+	p.db = nil
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	p.file.Bytes = info.Size()
+	log.Printf("[info] indexed %s in %s\n", p.path, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// insert the remaining rows and build the indexes
+func (p *geoPackage) finish() error {
+	for _, table := range gpkgTables {
+		if err := p.flush(table); err != nil {
+			return err
+		}
+	}
+	if err := p.commit(); err != nil {
+		return err
+	}
+	for _, table := range gpkgTables {
+		if err := p.exec(fmt.Sprintf(gpkgIndex, table)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// a null column value for empty strings
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// append the GeoPackage geometry header, the envelope is omitted for points
+func appendGPKGHeader(b []byte, bounds *geo.Bound) []byte {
+	flags := byte(1) // little endian
+	if bounds != nil {
+		flags |= 1 << 1 // envelope of [minx, maxx, miny, maxy]
+	}
+	b = append(b, 'G', 'P', 0, flags)
+	b = appendUint32(b, gpkgSRS)
+	if bounds != nil {
+		b = appendFloat64(b, bounds.West())
+		b = appendFloat64(b, bounds.East())
+		b = appendFloat64(b, bounds.South())
+		b = appendFloat64(b, bounds.North())
+	}
+	return b
+}
+
+// append a point as a GeoPackage geometry
+func appendGPKGPoint(b []byte, lon float64, lat float64) []byte {
+	return appendWKBPoint(appendGPKGHeader(b, nil), lon, lat, 0)
+}
+
+// append a way as a GeoPackage geometry
+func appendGPKGWay(b []byte, nodes []latLon, bounds *geo.Bound) []byte {
+	return appendWKBWay(appendGPKGHeader(b, bounds), nodes, 0)
+}
+
+// gpkgEnvelope - the [minx, maxx, miny, maxy] envelope of a GeoPackage
+// geometry, from its header or a point. ok is false for values which are not
+// a geometry or have no envelope.
+func gpkgEnvelope(value driver.Value) (envelope [4]float64, empty bool, ok bool) {
+	b, _ := value.([]byte)
+	if len(b) < 8 || b[0] != 'G' || b[1] != 'P' {
+		return envelope, false, false
+	}
+	flags := b[3]
+	empty = flags&(1<<4) != 0
+	var order binary.ByteOrder = binary.BigEndian
+	if flags&1 != 0 {
+		order = binary.LittleEndian
+	}
+
+	// an envelope of [minx, maxx, miny, maxy], followed by z and m for other codes
+	if code := flags >> 1 & 7; code > 0 {
+		if len(b) < 40 {
+			return envelope, false, false
+		}
+		for i := range envelope {
+			envelope[i] = math.Float64frombits(order.Uint64(b[8+8*i:]))
+		}
+		return envelope, empty, true
+	}
+
+	// otherwise only a point, in its own byte order
+	wkb := b[8:]
+	if empty || len(wkb) < 21 {
+		return envelope, empty, empty
+	}
+	order = binary.BigEndian
+	if wkb[0] == 1 {
+		order = binary.LittleEndian
+	}
+	if order.Uint32(wkb[1:])%1000 != wkbPoint {
+		return envelope, false, false
+	}
+	x, y := math.Float64frombits(order.Uint64(wkb[5:])), math.Float64frombits(order.Uint64(wkb[13:]))
+	return [4]float64{x, x, y, y}, math.IsNaN(x), true
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/paulmach/go.geo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGPKGPoint(t *testing.T) {
+	b := appendGPKGPoint(nil, -2.25, 1.5)
+	assert.Equal(t, []byte{'G', 'P', 0, 1, 0xE6, 0x10, 0, 0}, b[:8]) // no envelope, srs 4326
+	assert.Equal(t, byte(1), b[8])
+	assert.Equal(t, uint32(wkbPoint), binary.LittleEndian.Uint32(b[9:]))
+	assert.Equal(t, -2.25, math.Float64frombits(binary.LittleEndian.Uint64(b[13:])))
+	assert.Equal(t, 1.5, math.Float64frombits(binary.LittleEndian.Uint64(b[21:])))
+	assert.Len(t, b, 29)
+}
+
+func TestGPKGWay(t *testing.T) {
+	bounds := geo.NewBound(1, 2, 1, 2)
+
+	// an open way is a line
+	line := appendGPKGWay(nil, []latLon{{Lat: 1, Lon: 1}, {Lat: 2, Lon: 2}}, bounds)
+	assert.Equal(t, byte(3), line[3]) // little endian with an envelope
+	assert.Equal(t, 1.0, math.Float64frombits(binary.LittleEndian.Uint64(line[8:])))
+	assert.Equal(t, 2.0, math.Float64frombits(binary.LittleEndian.Uint64(line[16:])))
+	assert.Equal(t, uint32(wkbLineString), binary.LittleEndian.Uint32(line[41:]))
+	assert.Equal(t, uint32(2), binary.LittleEndian.Uint32(line[45:]))
+	assert.Len(t, line, 49+2*16)
+
+	// a closed way is a polygon with a single ring
+	ring := []latLon{{Lat: 1, Lon: 1}, {Lat: 1, Lon: 2}, {Lat: 2, Lon: 2}, {Lat: 1, Lon: 1}}
+	polygon := appendGPKGWay(nil, ring, bounds)
+	assert.Equal(t, uint32(wkbPolygon), binary.LittleEndian.Uint32(polygon[41:]))
+	assert.Equal(t, uint32(1), binary.LittleEndian.Uint32(polygon[45:]))
+	assert.Equal(t, uint32(4), binary.LittleEndian.Uint32(polygon[49:]))
+	assert.Len(t, polygon, 53+4*16)
+}
+
+func TestGeoPackage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.gpkg")
+	p := openGeoPackage(path, true)
+
+	version, ts := int32(3), time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.True(t, p.Node(&jsonNode{ID: 1, Type: "node", Layer: "venues", Lat: 1.5, Lon: -2.25, Tags: map[string]string{"amenity": "cafe"}, jsonMetadata: &jsonMetadata{Version: &version, Timestamp: &ts}}))
+	ring := []latLon{{Lat: 1, Lon: 1}, {Lat: 1, Lon: 2}, {Lat: 2, Lon: 2}, {Lat: 1, Lon: 1}}
+	centroid, bounds := computeCentroidAndBounds(ring)
+	assert.True(t, p.Way(&jsonWay{ID: 10, Type: "way", Tags: map[string]string{"building": "yes"}, Centroid: centroid, Bounds: bounds, Nodes: ring}))
+	assert.True(t, p.Relation(&jsonRelation{ID: 20, Type: "relation", Tags: map[string]interface{}{"name": []string{"a", "b"}}, Centroid: jsonCentroid{Lat: 5, Lon: 6, Type: "admin_centre"}, Bounds: geo.NewBound(4, 8, 3, 7), Partial: true}))
+	assert.Nil(t, p.Close())
+
+	db, err := sql.Open("sqlite", path)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	var applicationID, userVersion int
+	assert.Nil(t, db.QueryRow("PRAGMA application_id").Scan(&applicationID))
+	assert.Nil(t, db.QueryRow("PRAGMA user_version").Scan(&userVersion))
+	assert.Equal(t, gpkgApplicationID, applicationID)
+	assert.Equal(t, gpkgUserVersion, userVersion)
+
+	// nodes
+	var id int64
+	var layer, tags, timestamp string
+	var lat, lon float64
+	var minLon sql.NullFloat64
+	var nodeVersion int
+	var geom []byte
+	assert.Nil(t, db.QueryRow("SELECT id, layer, tags, lat, lon, min_lon, version, timestamp || '', geom FROM nodes").Scan(&id, &layer, &tags, &lat, &lon, &minLon, &nodeVersion, &timestamp, &geom))
+	assert.Equal(t, int64(1), id)
+	assert.Equal(t, "venues", layer)
+	assert.Equal(t, `{"amenity":"cafe"}`, tags)
+	assert.Equal(t, []float64{1.5, -2.25}, []float64{lat, lon})
+	assert.False(t, minLon.Valid)
+	assert.Equal(t, 3, nodeVersion)
+	assert.Equal(t, "2020-01-02T03:04:05.000Z", timestamp)
+	assert.Equal(t, appendGPKGPoint(nil, -2.25, 1.5), geom)
+
+	// ways
+	var partial bool
+	assert.Nil(t, db.QueryRow("SELECT json_extract(tags, '$.building'), min_lon, partial, geom FROM ways WHERE id = 10").Scan(&tags, &minLon, &partial, &geom))
+	assert.Equal(t, "yes", tags)
+	assert.Equal(t, 1.0, minLon.Float64)
+	assert.False(t, partial)
+	assert.Equal(t, appendGPKGWay(nil, ring, bounds), geom)
+
+	// relations
+	var centroidType string
+	assert.Nil(t, db.QueryRow("SELECT tags, centroid_type, partial FROM relations").Scan(&tags, &centroidType, &partial))
+	assert.Equal(t, `{"name":["a","b"]}`, tags)
+	assert.Equal(t, "admin_centre", centroidType)
+	assert.True(t, partial)
+
+	// spatial index and extents
+	var count int
+	assert.Nil(t, db.QueryRow("SELECT count(*) FROM rtree_relations_geom WHERE minx >= 3.9 AND maxx <= 8.1 AND miny >= 2.9 AND maxy <= 7.1").Scan(&count))
+	assert.Equal(t, 1, count)
+	assert.Nil(t, db.QueryRow("SELECT count(*) FROM gpkg_extensions WHERE extension_name = 'gpkg_rtree_index'").Scan(&count))
+	assert.Equal(t, 3, count)
+	var extents [4]float64
+	assert.Nil(t, db.QueryRow("SELECT min_x, min_y, max_x, max_y FROM gpkg_contents WHERE table_name = 'ways'").Scan(&extents[0], &extents[1], &extents[2], &extents[3]))
+	assert.Equal(t, [4]float64{1, 1, 2, 2}, extents)
+
+	var geometryType string
+	assert.Nil(t, db.QueryRow("SELECT geometry_type_name FROM gpkg_geometry_columns WHERE table_name = 'ways'").Scan(&geometryType))
+	assert.Equal(t, "GEOMETRY", geometryType)
+
+	// the triggers keep the index up to date
+	assert.Nil(t, db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'rtree_%'").Scan(&count))
+	assert.Equal(t, 18, count)
+	_, err = db.Exec("DELETE FROM relations WHERE id = 20")
+	assert.Nil(t, err)
+	assert.Nil(t, db.QueryRow("SELECT count(*) FROM rtree_relations_geom").Scan(&count))
+	assert.Equal(t, 0, count)
+	_, err = db.Exec("UPDATE nodes SET geom = ?", appendGPKGPoint(nil, 10, 20))
+	assert.Nil(t, err)
+	assert.Nil(t, db.QueryRow("SELECT count(*) FROM rtree_nodes_geom WHERE minx = 10 AND miny = 20").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestGeoPackageRelationGeometry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.gpkg")
+	p := openGeoPackage(path, true)
+	geometry := &shape{polygons: [][][]latLon{{square(0, 0, 1)}, {square(2, 2, 1)}}}
+	assert.True(t, p.Relation(&jsonRelation{ID: 20, Type: "relation", Tags: map[string]interface{}{}, Centroid: jsonCentroid{Lat: 0.5, Lon: 0.5}, Bounds: geo.NewBound(0, 1, 0, 1), Geometry: geometry}))
+	assert.Nil(t, p.Close())
+
+	db, err := sql.Open("sqlite", path)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// the index is the extent of the geometry, rather than the bounds
+	var geom []byte
+	var extents [4]float64
+	assert.Nil(t, db.QueryRow("SELECT geom FROM relations").Scan(&geom))
+	assert.Equal(t, uint32(wkbMultiPolygon), binary.LittleEndian.Uint32(geom[41:]))
+	assert.Nil(t, db.QueryRow("SELECT minx, maxx, miny, maxy FROM rtree_relations_geom").Scan(&extents[0], &extents[1], &extents[2], &extents[3]))
+	assert.Equal(t, [4]float64{0, 3, 0, 3}, extents)
+	var geometryType string
+	assert.Nil(t, db.QueryRow("SELECT geometry_type_name FROM gpkg_geometry_columns WHERE table_name = 'relations'").Scan(&geometryType))
+	assert.Equal(t, "GEOMETRY", geometryType)
+}
+
+func TestGPKGEnvelope(t *testing.T) {
+	envelope, empty, ok := gpkgEnvelope(appendGPKGPoint(nil, -2.25, 1.5))
+	assert.Equal(t, [4]float64{-2.25, -2.25, 1.5, 1.5}, envelope)
+	assert.False(t, empty)
+	assert.True(t, ok)
+
+	envelope, _, ok = gpkgEnvelope(appendGPKGWay(nil, []latLon{{Lat: 1, Lon: 2}, {Lat: 3, Lon: 4}}, geo.NewBound(2, 4, 1, 3)))
+	assert.Equal(t, [4]float64{2, 4, 1, 3}, envelope)
+	assert.True(t, ok)
+
+	// an empty point has NaN coordinates
+	_, empty, ok = gpkgEnvelope(appendGPKGPoint(nil, math.NaN(), math.NaN()))
+	assert.True(t, empty)
+	assert.True(t, ok)
+
+	// not a geometry
+	_, _, ok = gpkgEnvelope([]byte("GP"))
+	assert.False(t, ok)
+	_, _, ok = gpkgEnvelope(nil)
+	assert.False(t, ok)
+}
+
+func TestGeoPackageBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.gpkg")
+	p := openGeoPackage(path, false)
+	for i := 1; i <= 2*gpkgInsertRows+3; i++ {
+		p.Node(&jsonNode{ID: int64(i), Type: "node", Tags: map[string]string{}})
+	}
+	assert.Nil(t, p.Close())
+
+	db, err := sql.Open("sqlite", path)
+	assert.Nil(t, err)
+	defer db.Close()
+	var count, max int
+	assert.Nil(t, db.QueryRow("SELECT count(*), max(id) FROM nodes").Scan(&count, &max))
+	assert.Equal(t, 2*gpkgInsertRows+3, count)
+	assert.Equal(t, 2*gpkgInsertRows+3, max)
+}
@@ -1,6 +1,9 @@
 function generateParams(config) {
   const flags = [];
 
+  if( config.config ){
+    flags.push( `-config=${config.config}` );
+  }
   if (config.tags) {
     const tags = config.tags.join(',');
     flags.push( `-tags=${tags}` );
@@ -11,8 +14,97 @@ function generateParams(config) {
   if( config.hasOwnProperty( 'waynodes' ) ){
     flags.push( `--waynodes=${config.waynodes}` );
   }
+  if( config.geometry ){
+    flags.push( `-geometry=${config.geometry}` );
+  }
+  if( config.simplify ){
+    flags.push( `-simplify=${config.simplify}` );
+  }
+  if( config.normaliseTags ){
+    flags.push( '-normalise-tags' );
+  }
+  if( config.output ){
+    flags.push( `-output=${config.output}` );
+  }
+  if( config.split ){
+    flags.push( `-split=${config.split}` );
+  }
+  if( config.shards ){
+    flags.push( `-shards=${config.shards}` );
+  }
+  if( config.rotateRecords ){
+    flags.push( `-rotate-records=${config.rotateRecords}` );
+  }
+  if( config.rotateBytes ){
+    flags.push( `-rotate-bytes=${config.rotateBytes}` );
+  }
+  if( config.compress ){
+    flags.push( `-compress=${config.compress}` );
+  }
+  if( config.format ){
+    flags.push( `-format=${config.format}` );
+  }
+  if( config.esIndex ){
+    flags.push( `-es-index=${config.esIndex}` );
+  }
+  if( config.peliasConfig ){
+    flags.push( `-pelias-config=${config.peliasConfig}` );
+  }
+  if( config.columns ){
+    flags.push( `-columns=${[].concat( config.columns ).join(',')}` );
+  }
+  if( config.hasOwnProperty( 'fgbIndex' ) ){
+    flags.push( `-fgb-index=${config.fgbIndex}` );
+  }
+  if( config.pgTags ){
+    flags.push( `-pg-tags=${config.pgTags}` );
+  }
+  if( config.schema ){
+    flags.push( `-schema=${config.schema}` );
+  }
+  if( config.hasOwnProperty( 'precision' ) ){
+    flags.push( `-precision=${config.precision}` );
+  }
+  if( config.keepTags ){
+    flags.push( `-keep-tags=${[].concat( config.keepTags ).join(',')}` );
+  }
+  if( config.dropTags ){
+    flags.push( `-drop-tags=${[].concat( config.dropTags ).join(',')}` );
+  }
+  if( config.transform ){
+    flags.push( `-transform=${config.transform}` );
+  }
+  if( config.newerThan ){
+    flags.push( `-newer-than=${config.newerThan}` );
+  }
+  if( config.olderThan ){
+    flags.push( `-older-than=${config.olderThan}` );
+  }
+  if( config.user ){
+    flags.push( `-user=${[].concat( config.user ).join(',')}` );
+  }
+  if( config.uid ){
+    flags.push( `-uid=${[].concat( config.uid ).join(',')}` );
+  }
+  if( config.minVersion ){
+    flags.push( `-min-version=${config.minVersion}` );
+  }
+  if( config.metadata ){
+    const metadata = [].concat( config.metadata ).join(',');
+    flags.push( `-metadata=${metadata}` );
+  }
+  if( config.missing ){
+    flags.push( `-missing=${config.missing}` );
+  }
+  if( config.missingReport ){
+    flags.push( `-missing-report=${config.missingReport}` );
+  }
+  if( config.keepCache ){
+    flags.push( '-keep-cache' );
+  }
 
-  flags.push( config.file );
+  // several files may be provided, they are merged in to a single stream
+  [].concat( config.file ).forEach( file => flags.push( file ) );
 
   return flags;
 }
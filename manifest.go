@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/qedus/osmpbf"
+)
+
+// manifest - a machine-readable description of a run
+type manifest struct {
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	Inputs   []*manifestInput  `json:"inputs"`
+	Flags    map[string]string `json:"flags"`
+	Stats    *stats            `json:"stats"`
+	Outputs  []*outputFile     `json:"outputs,omitempty"` // every file the outputs wrote
+	checksum sync.WaitGroup
+	errs     []error // of each checksum, only read once they are complete
+}
+
+// manifestInput - describes an input file
+type manifestInput struct {
+	Path   string          `json:"path"`
+	Size   int64           `json:"size"`
+	SHA256 string          `json:"sha256"`
+	Header *manifestHeader `json:"header"`
+}
+
+// manifestHeader - the interesting parts of the PBF file header
+type manifestHeader struct {
+	Bounds               map[string]float64 `json:"bounds,omitempty"`
+	ReplicationTimestamp *time.Time         `json:"replication_timestamp,omitempty"`
+	ReplicationSequence  int64              `json:"replication_sequence,omitempty"`
+	ReplicationURL       string             `json:"replication_url,omitempty"`
+	WritingProgram       string             `json:"writing_program,omitempty"`
+	Source               string             `json:"source,omitempty"`
+}
+
+// newManifest - describe the input files and the effective flags. The input
+// checksums are computed in the background while the passes are running.
+func newManifest(paths []string, files []*os.File, stats *stats) *manifest {
+	m := &manifest{
+		Started: time.Now().UTC(),
+		Flags:   make(map[string]string),
+		Stats:   stats,
+		errs:    make([]error, len(files)),
+	}
+
+	// record every flag, including defaults
+	flag.VisitAll(func(f *flag.Flag) {
+		m.Flags[f.Name] = f.Value.String()
+	})
+
+	for i, file := range files {
+		info, err := file.Stat()
+		if err != nil {
+			fatal(err)
+		}
+		input := &manifestInput{
+			Path:   paths[i],
+			Size:   info.Size(),
+			Header: readHeader(io.NewSectionReader(file, 0, info.Size())),
+		}
+		m.Inputs = append(m.Inputs, input)
+
+		// note: a section reader doesn't affect the offset used by the decoders,
+		// errors are reported by Write rather than exiting from this goroutine
+		m.checksum.Add(1)
+		go func(i int, r io.Reader) {
+			defer m.checksum.Done()
+			hash := sha256.New()
+			if _, err := io.Copy(hash, r); err != nil {
+				m.errs[i] = fmt.Errorf("checksum of %s failed: %v", paths[i], err)
+				return
+			}
+			input.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		}(i, io.NewSectionReader(file, 0, info.Size()))
+	}
+
+	return m
+}
+
+// read the header block of a PBF file
+func readHeader(r io.Reader) *manifestHeader {
+	decoder := osmpbf.NewDecoder(r)
+
+	// note: the first call reads the header, but returns it unset
+	if _, err := decoder.Header(); err != nil {
+		fatal(err)
+	}
+	header, _ := decoder.Header()
+
+	h := &manifestHeader{
+		ReplicationSequence: header.OsmosisReplicationSequenceNumber,
+		ReplicationURL:      header.OsmosisReplicationBaseUrl,
+		WritingProgram:      header.WritingProgram,
+		Source:              header.Source,
+	}
+	if bbox := header.BoundingBox; bbox != nil {
+		h.Bounds = map[string]float64{"n": bbox.Top, "s": bbox.Bottom, "e": bbox.Right, "w": bbox.Left}
+	}
+	if ts := header.OsmosisReplicationTimestamp; !ts.IsZero() {
+		ts = ts.UTC()
+		h.ReplicationTimestamp = &ts
+	}
+	return h
+}
+
+// Write - wait for the checksums to complete and write the manifest to path
+func (m *manifest) Write(path string) error {
+	m.checksum.Wait()
+	for _, err := range m.errs {
+		if err != nil {
+			return err
+		}
+	}
+	m.Finished = time.Now().UTC()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
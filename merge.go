@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// element kinds, used to namespace the merge bookkeeping keys
+const (
+	kindNode     byte = 'n'
+	kindWay      byte = 'w'
+	kindRelation byte = 'r'
+)
+
+// key prefixes for the merge bookkeeping, these never collide with the
+// cache keys which are either numeric (nodes) or prefixed with 'W' (ways)
+const (
+	ownerPrefix  = "O" // the input file holding the copy of an element to print
+	cachedPrefix = "C" // the version of an element written to the cache
+)
+
+// the owner of an element which must not be printed by any input file
+const noFile = 0xFFFF
+
+// merger - deduplicates elements which appear in several overlapping input
+// files so that only the copy with the highest version is used, in the
+// case of a tie the copy from the first file wins.
+// note: a nil *merger is valid and means there is only a single input file.
+type merger struct {
+	db        *leveldb.DB
+	batch     *leveldb.Batch
+	batchSize int
+	file      int
+	stats     *stats
+}
+
+// newMerger - constructor
+func newMerger(db *leveldb.DB, batchSize int, stats *stats) *merger {
+	return &merger{
+		db:        db,
+		batch:     new(leveldb.Batch),
+		batchSize: batchSize,
+		stats:     stats,
+	}
+}
+
+// setFile - select the input file currently being decoded
+func (m *merger) setFile(file int) {
+	if m == nil {
+		return
+	}
+	m.flush()
+	m.file = file
+}
+
+// flush - write outstanding bookkeeping to leveldb
+func (m *merger) flush() {
+	if m == nil || m.batch.Len() == 0 {
+		return
+	}
+	cacheFlush(m.db, m.batch, true)
+}
+
+// claim - record the current file as the source of an element if its copy
+// is newer than any copy seen so far, returns true if the claim succeeded.
+// note: element IDs are unique within a file, so bookkeeping still waiting
+// in the batch never needs to be consulted.
+func (m *merger) claim(prefix string, kind byte, id int64, version int32) bool {
+	if m == nil {
+		return true
+	}
+	key := mergeKey(prefix, kind, id)
+	if seen, _, found := m.lookup(key); found && version <= seen {
+		return false
+	}
+	m.put(key, version, m.file)
+	return true
+}
+
+// supersede - a copy of an element newer than the printable copy was found,
+// since it didn't match itself, the element must not be printed at all.
+func (m *merger) supersede(kind byte, id int64, version int32) {
+	if m == nil {
+		return
+	}
+	key := mergeKey(ownerPrefix, kind, id)
+	if seen, _, found := m.lookup(key); found && version > seen {
+		m.put(key, version, noFile)
+	}
+}
+
+// owns - check if the current file holds the copy of an element to print
+func (m *merger) owns(kind byte, id int64) bool {
+	if m == nil {
+		return true
+	}
+	_, file, found := m.lookup(mergeKey(ownerPrefix, kind, id))
+	if !found || file != m.file {
+		m.stats.CountSkipped(skipDuplicate)
+		return false
+	}
+	return true
+}
+
+func (m *merger) lookup(key []byte) (int32, int, bool) {
+	data, err := m.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return 0, 0, false
+	} else if err != nil {
+		fatal(err)
+	}
+	version, file := bytesToOwner(data)
+	return version, file, true
+}
+
+func (m *merger) put(key []byte, version int32, file int) {
+	m.batch.Put(key, ownerToBytes(version, file))
+	if m.batch.Len() > m.batchSize {
+		m.flush()
+	}
+}
+
+func mergeKey(prefix string, kind byte, id int64) []byte {
+	return []byte(prefix + string(kind) + strconv.FormatInt(id, 10))
+}
+
+// encode an element version and file index as bytes (6 bytes used)
+func ownerToBytes(version int32, file int) []byte {
+	buf := make([]byte, 6)
+	binary.BigEndian.PutUint32(buf, uint32(version))
+	binary.BigEndian.PutUint16(buf[4:], uint16(file))
+	return buf
+}
+
+// decode bytes to an element version and file index
+func bytesToOwner(data []byte) (int32, int) {
+	if len(data) != 6 {
+		fatal("invalid byte slice length: expected 6 bytes")
+	}
+	return int32(binary.BigEndian.Uint32(data)), int(binary.BigEndian.Uint16(data[4:]))
+}
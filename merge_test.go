@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodingAndDecodingOwner(t *testing.T) {
+
+	var version, file = bytesToOwner(ownerToBytes(123456, 7))
+	assert.Equal(t, int32(123456), version)
+	assert.Equal(t, 7, file)
+}
+
+func TestMergerNil(t *testing.T) {
+
+	// a single input file always claims and owns every element
+	var merge *merger
+	merge.setFile(0)
+	assert.True(t, merge.claim(ownerPrefix, kindNode, 1, 1))
+	assert.True(t, merge.owns(kindNode, 1))
+	merge.supersede(kindNode, 1, 2)
+	merge.flush()
+}
+
+func TestMergerNewestVersionWins(t *testing.T) {
+
+	var db = openLevelDB(t.TempDir())
+	defer db.Close()
+
+	var merge = newMerger(db, 10, newStats(nil))
+
+	merge.setFile(0)
+	assert.True(t, merge.claim(ownerPrefix, kindNode, 1, 1))
+	assert.True(t, merge.claim(ownerPrefix, kindWay, 1, 5))
+
+	merge.setFile(1)
+	assert.True(t, merge.claim(ownerPrefix, kindNode, 1, 2)) // newer
+	assert.False(t, merge.claim(ownerPrefix, kindWay, 1, 5)) // tie, first file wins
+	merge.flush()
+
+	merge.setFile(0)
+	assert.False(t, merge.owns(kindNode, 1))
+	assert.True(t, merge.owns(kindWay, 1))
+	assert.False(t, merge.owns(kindRelation, 1)) // never claimed
+
+	merge.setFile(1)
+	assert.True(t, merge.owns(kindNode, 1))
+	assert.False(t, merge.owns(kindWay, 1))
+}
+
+func TestMergerSupersede(t *testing.T) {
+
+	var db = openLevelDB(t.TempDir())
+	defer db.Close()
+
+	var merge = newMerger(db, 10, newStats(nil))
+
+	merge.setFile(0)
+	merge.claim(ownerPrefix, kindNode, 1, 2)
+	merge.claim(ownerPrefix, kindNode, 2, 2)
+	merge.flush()
+
+	// an older copy does not affect the owner
+	merge.setFile(1)
+	merge.supersede(kindNode, 1, 1)
+
+	// a newer copy withdraws the element
+	merge.supersede(kindNode, 2, 3)
+	merge.flush()
+
+	merge.setFile(0)
+	assert.True(t, merge.owns(kindNode, 1))
+	assert.False(t, merge.owns(kindNode, 2))
+	merge.setFile(1)
+	assert.False(t, merge.owns(kindNode, 2))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qedus/osmpbf"
+)
+
+// element metadata fields which may be printed with each record
+var metadataFields = []string{"version", "timestamp", "changeset", "uid", "user", "visible"}
+
+// jsonMetadata - the selected metadata of an element, embedded in each record.
+// note: fields are pointers so that zero values (eg. visible=false) are
+// still printed when selected.
+type jsonMetadata struct {
+	Version   *int32     `json:"version,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Changeset *int64     `json:"changeset,omitempty"`
+	UID       *int32     `json:"uid,omitempty"`
+	User      *string    `json:"user,omitempty"`
+	Visible   *bool      `json:"visible,omitempty"`
+}
+
+// parseMetadataFields - parse a comma-separated list of metadata fields
+func parseMetadataFields(list string) (map[string]bool, error) {
+	fields := make(map[string]bool)
+	if len(list) < 1 {
+		return fields, nil
+	}
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if !isMetadataField(field) {
+			return nil, fmt.Errorf("unknown metadata field '%s', expected one of: %s", field, strings.Join(metadataFields, ","))
+		}
+		fields[field] = true
+	}
+	return fields, nil
+}
+
+func isMetadataField(field string) bool {
+	for _, valid := range metadataFields {
+		if field == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// newMetadata - select fields from the element info, returns nil if no
+// fields are selected so nothing is added to the record
+func newMetadata(fields map[string]bool, info osmpbf.Info) *jsonMetadata {
+	if len(fields) == 0 {
+		return nil
+	}
+	meta := &jsonMetadata{}
+	if fields["version"] {
+		meta.Version = &info.Version
+	}
+	if fields["timestamp"] {
+		timestamp := info.Timestamp.UTC()
+		meta.Timestamp = &timestamp
+	}
+	if fields["changeset"] {
+		meta.Changeset = &info.Changeset
+	}
+	if fields["uid"] {
+		meta.UID = &info.Uid
+	}
+	if fields["user"] {
+		meta.User = &info.User
+	}
+	if fields["visible"] {
+		meta.Visible = &info.Visible
+	}
+	return meta
+}
+
+// metadataFilter - conditions on the element metadata which must all be met
+// for an element to be matched, in addition to the tag conditions.
+// note: a nil *metadataFilter is valid and matches every element.
+type metadataFilter struct {
+	NewerThan  time.Time       // edited at or after this time
+	OlderThan  time.Time       // edited before this time
+	Users      map[string]bool // edited by one of these users
+	UIDs       map[int32]bool  // edited by one of these user IDs
+	MinVersion int32           // at least this version
+}
+
+// newMetadataFilter - parse the filter flags, returns nil if no filters are set
+func newMetadataFilter(newerThan, olderThan, users, uids string, minVersion int) (*metadataFilter, error) {
+	if newerThan == "" && olderThan == "" && users == "" && uids == "" && minVersion <= 0 {
+		return nil, nil
+	}
+	filter := &metadataFilter{MinVersion: int32(minVersion)}
+
+	var err error
+	if filter.NewerThan, err = parseTimestamp(newerThan); err != nil {
+		return nil, err
+	}
+	if filter.OlderThan, err = parseTimestamp(olderThan); err != nil {
+		return nil, err
+	}
+
+	if users != "" {
+		filter.Users = make(map[string]bool)
+		for _, user := range strings.Split(users, ",") {
+			filter.Users[strings.TrimSpace(user)] = true
+		}
+	}
+
+	if uids != "" {
+		filter.UIDs = make(map[int32]bool)
+		for _, uid := range strings.Split(uids, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(uid), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid uid '%s'", uid)
+			}
+			filter.UIDs[int32(id)] = true
+		}
+	}
+
+	return filter, nil
+}
+
+// parse a date (2006-01-02) or a full RFC 3339 timestamp, an empty string
+// returns the zero time
+func parseTimestamp(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, fmt.Errorf("invalid timestamp '%s', expected YYYY-MM-DD or RFC 3339", value)
+	}
+	return t, nil
+}
+
+// Match - check the metadata of an element against all conditions
+func (f *metadataFilter) Match(info osmpbf.Info) bool {
+	if f == nil {
+		return true
+	}
+	if !f.NewerThan.IsZero() && info.Timestamp.Before(f.NewerThan) {
+		return false
+	}
+	if !f.OlderThan.IsZero() && !info.Timestamp.Before(f.OlderThan) {
+		return false
+	}
+	if f.Users != nil && !f.Users[info.User] {
+		return false
+	}
+	if f.UIDs != nil && !f.UIDs[info.Uid] {
+		return false
+	}
+	return info.Version >= f.MinVersion
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/qedus/osmpbf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetadataFields(t *testing.T) {
+	fields, err := parseMetadataFields("")
+	assert.Nil(t, err)
+	assert.Empty(t, fields)
+
+	fields, err = parseMetadataFields("version, user")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"version": true, "user": true}, fields)
+
+	_, err = parseMetadataFields("version,colour")
+	assert.NotNil(t, err)
+}
+
+func TestNewMetadata(t *testing.T) {
+	info := osmpbf.Info{
+		Version:   3,
+		Uid:       42,
+		Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Changeset: 1234,
+		User:      "mapper",
+		Visible:   false,
+	}
+
+	// nothing selected
+	assert.Nil(t, newMetadata(map[string]bool{}, info))
+
+	// all fields, zero values are printed
+	fields, _ := parseMetadataFields("version,timestamp,changeset,uid,user,visible")
+	data, err := json.Marshal(newMetadata(fields, info))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"version":3,"timestamp":"2020-01-02T03:04:05Z","changeset":1234,"uid":42,"user":"mapper","visible":false}`, string(data))
+
+	// a subset of fields
+	fields, _ = parseMetadataFields("user")
+	data, _ = json.Marshal(newMetadata(fields, info))
+	assert.Equal(t, `{"user":"mapper"}`, string(data))
+}
+
+func TestMetadataEmbedded(t *testing.T) {
+	fields, _ := parseMetadataFields("version")
+	node := jsonNode{ID: 1, Type: "node", jsonMetadata: newMetadata(fields, osmpbf.Info{Version: 2})}
+	encoder := newRecordEncoder(schemaV1, defaultPrecision)
+	data := encoder.Node(&node)
+	assert.Equal(t, `{"id":1,"type":"node","lat":0,"lon":0,"tags":null,"version":2}`, string(data))
+
+	// no metadata selected
+	node.jsonMetadata = nil
+	data = encoder.Node(&node)
+	assert.Equal(t, `{"id":1,"type":"node","lat":0,"lon":0,"tags":null}`, string(data))
+}
+
+func TestNewMetadataFilter(t *testing.T) {
+
+	// no filters
+	filter, err := newMetadataFilter("", "", "", "", 0)
+	assert.Nil(t, err)
+	assert.Nil(t, filter)
+	assert.True(t, filter.Match(osmpbf.Info{}))
+
+	filter, err = newMetadataFilter("2020-01-01", "2021-06-01T12:00:00Z", "alice, bob", "1,2", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), filter.NewerThan)
+	assert.Equal(t, time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), filter.OlderThan)
+	assert.Equal(t, map[string]bool{"alice": true, "bob": true}, filter.Users)
+	assert.Equal(t, map[int32]bool{1: true, 2: true}, filter.UIDs)
+	assert.Equal(t, int32(3), filter.MinVersion)
+
+	// invalid values
+	_, err = newMetadataFilter("yesterday", "", "", "", 0)
+	assert.NotNil(t, err)
+	_, err = newMetadataFilter("", "", "", "alice", 0)
+	assert.NotNil(t, err)
+}
+
+func TestMetadataFilterMatch(t *testing.T) {
+	info := osmpbf.Info{
+		Version:   3,
+		Uid:       1,
+		Timestamp: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+		User:      "alice",
+	}
+
+	filter, _ := newMetadataFilter("2020-06-01", "", "", "", 0)
+	assert.True(t, filter.Match(info))
+	filter, _ = newMetadataFilter("2020-06-02", "", "", "", 0)
+	assert.False(t, filter.Match(info))
+
+	filter, _ = newMetadataFilter("", "2020-06-02", "", "", 0)
+	assert.True(t, filter.Match(info))
+	filter, _ = newMetadataFilter("", "2020-06-01", "", "", 0)
+	assert.False(t, filter.Match(info))
+
+	filter, _ = newMetadataFilter("", "", "alice,bob", "", 0)
+	assert.True(t, filter.Match(info))
+	filter, _ = newMetadataFilter("", "", "bob", "", 0)
+	assert.False(t, filter.Match(info))
+
+	filter, _ = newMetadataFilter("", "", "", "1", 0)
+	assert.True(t, filter.Match(info))
+	filter, _ = newMetadataFilter("", "", "", "2", 0)
+	assert.False(t, filter.Match(info))
+
+	filter, _ = newMetadataFilter("", "", "", "", 3)
+	assert.True(t, filter.Match(info))
+	filter, _ = newMetadataFilter("", "", "", "", 4)
+	assert.False(t, filter.Match(info))
+
+	// all conditions must be met
+	filter, _ = newMetadataFilter("2020-01-01", "", "bob", "", 0)
+	assert.False(t, filter.Match(info))
+}
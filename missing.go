@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+)
+
+// policies for elements which reference nodes or ways missing from the cache
+const (
+	missingDrop     = "drop"      // do not print the element
+	missingSkipRefs = "skip-refs" // print the element using the references which were found
+	missingFail     = "fail"      // abort the run
+)
+
+// outcomes recorded in the failure report
+const (
+	statusDropped = "dropped" // the element was not printed
+	statusPartial = "partial" // printed from the references which were found, marked as partial
+	statusFailed  = "failed"  // the run was aborted
+)
+
+// missingRefs - references of an element which could not be found in the cache
+type missingRefs struct {
+	Nodes []int64 `json:"nodes,omitempty"`
+	Ways  []int64 `json:"ways,omitempty"`
+}
+
+// Empty - true if all references were found
+func (m missingRefs) Empty() bool {
+	return len(m.Nodes) == 0 && len(m.Ways) == 0
+}
+
+// failureReport - an NDJSON file listing every element with missing references.
+// note: a nil *failureReport is valid and reports nothing.
+type failureReport struct {
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// failure - a single line of the failure report
+type failure struct {
+	ID      int64       `json:"id"`
+	Type    string      `json:"type"`
+	Status  string      `json:"status"`
+	Missing missingRefs `json:"missing"`
+}
+
+// openFailureReport - create the report file, returns nil if no path is specified
+func openFailureReport(path string) *failureReport {
+	if len(path) < 1 {
+		return nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		fatal(err)
+	}
+	writer := bufio.NewWriter(file)
+	return &failureReport{file, writer, json.NewEncoder(writer)}
+}
+
+// Write - record an element with missing references
+func (r *failureReport) Write(kind string, id int64, status string, missing missingRefs) {
+	if r == nil {
+		return
+	}
+	if err := r.encoder.Encode(failure{id, kind, status, missing}); err != nil {
+		fatal(err)
+	}
+}
+
+// Close - flush the report to disk
+func (r *failureReport) Close() error {
+	if r == nil {
+		return nil
+	}
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// applyMissingPolicy - decide what to do with an element with missing references,
+// found is the number of its references which were found. The outcome is
+// recorded in the failure report and returned.
+func applyMissingPolicy(policy string, report *failureReport, kind string, id int64, missing missingRefs, found int) string {
+	status := statusDropped
+	switch {
+	case policy == missingFail:
+		status = statusFailed
+	case found == 0:
+		status = statusDropped
+	case policy == missingSkipRefs, kind == "relation":
+		// when dropping, relations are printed without the member ways which failed
+		status = statusPartial
+	}
+
+	report.Write(kind, id, status, missing)
+	if status == statusFailed {
+		fatalf("[error] denormalize failed for %s: %d, nodes not found: %v, ways not found: %v", kind, id, missing.Nodes, missing.Ways)
+	}
+	return status
+}
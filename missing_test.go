@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissingRefsEmpty(t *testing.T) {
+	assert.True(t, missingRefs{}.Empty())
+	assert.False(t, missingRefs{Nodes: []int64{1}}.Empty())
+	assert.False(t, missingRefs{Ways: []int64{2}}.Empty())
+}
+
+func TestFailureReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failures.ndjson")
+	report := openFailureReport(path)
+	report.Write("way", 11, statusDropped, missingRefs{Nodes: []int64{99}})
+	report.Write("relation", 20, statusPartial, missingRefs{Ways: []int64{98}})
+	report.Close()
+
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	var lines []failure
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line failure
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+
+	assert.Equal(t, []failure{
+		{11, "way", statusDropped, missingRefs{Nodes: []int64{99}}},
+		{20, "relation", statusPartial, missingRefs{Ways: []int64{98}}},
+	}, lines)
+}
+
+func TestFailureReportDisabled(t *testing.T) {
+	report := openFailureReport("")
+	assert.Nil(t, report)
+
+	// a nil report is safe to use
+	report.Write("way", 1, statusDropped, missingRefs{Nodes: []int64{2}})
+	report.Close()
+}
+
+func TestApplyMissingPolicy(t *testing.T) {
+	missing := missingRefs{Nodes: []int64{99}}
+
+	// drop
+	assert.Equal(t, statusDropped, applyMissingPolicy(missingDrop, nil, "way", 1, missing, 3))
+	assert.Equal(t, statusPartial, applyMissingPolicy(missingDrop, nil, "relation", 1, missing, 3))
+	assert.Equal(t, statusDropped, applyMissingPolicy(missingDrop, nil, "relation", 1, missing, 0))
+
+	// skip-refs
+	assert.Equal(t, statusPartial, applyMissingPolicy(missingSkipRefs, nil, "way", 1, missing, 3))
+	assert.Equal(t, statusPartial, applyMissingPolicy(missingSkipRefs, nil, "relation", 1, missing, 3))
+	assert.Equal(t, statusDropped, applyMissingPolicy(missingSkipRefs, nil, "way", 1, missing, 0))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ways to split the records written to an output directory
+const (
+	splitNone  = "none"  // a single file
+	splitType  = "type"  // a file per element type
+	splitLayer = "layer" // a file per layer
+)
+
+// recordWriter - writes encoded records, one per line
+type recordWriter interface {
+	WriteRecord(kind string, id int64, record []byte)
+}
+
+// streamWriter - writes every record to a single stream, which should be
+// buffered as each record is written in two parts
+type streamWriter struct {
+	w io.Writer
+}
+
+var newline = []byte{'\n'}
+
+func (s *streamWriter) WriteRecord(kind string, id int64, record []byte) {
+	if _, err := s.w.Write(record); err != nil {
+		fatal(err)
+	}
+	if _, err := s.w.Write(newline); err != nil {
+		fatal(err)
+	}
+}
+
+// write the header line of a file
+func writeHeader(w io.Writer, header []byte) {
+	if _, err := w.Write(header); err != nil {
+		fatal(err)
+	}
+	if _, err := w.Write(newline); err != nil {
+		fatal(err)
+	}
+}
+
+// outputDir - writes records to files in a directory, split by element type or
+// layer, sharded by a hash of the element ID and rotated by size.
+type outputDir struct {
+	path          string
+	split         string
+	shards        int
+	rotateRecords int64
+	rotateBytes   int64
+	ext           string // extension of the output format
+	header        []byte // written at the start of every file
+	compression   string
+	open          map[string]*outputFile // the file currently written for each name
+	Files         []*outputFile          // every file written, in the order they were created
+}
+
+// outputFile - a file written by the outputs, listed in the manifest
+type outputFile struct {
+	Path    string `json:"path"`
+	Records int64  `json:"records"`
+	Bytes   int64  `json:"bytes"` // before compression
+	seq     int    // rotation number
+	stream  *outputStream
+}
+
+// openOutputFile - create a file of records, starting with a header line
+// when header is not nil
+func openOutputFile(path string, compression string, header []byte) *outputFile {
+	f := &outputFile{Path: path, stream: openOutputStream(path, compression)}
+	if header != nil {
+		writeHeader(f.stream, header)
+		f.Bytes += int64(len(header) + 1)
+	}
+	return f
+}
+
+// fileWriter - writes every record to a single file
+type fileWriter struct {
+	f *outputFile
+}
+
+func (w *fileWriter) WriteRecord(kind string, id int64, record []byte) {
+	w.f.write(record)
+}
+
+// newOutputDir - constructor, the directory is created if it does not exist
+func newOutputDir(path string, split string, shards int, rotateRecords int64, rotateBytes int64, ext string, header []byte, compression string) *outputDir {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		fatal(err)
+	}
+	return &outputDir{
+		path:          path,
+		split:         split,
+		shards:        shards,
+		rotateRecords: rotateRecords,
+		rotateBytes:   rotateBytes,
+		ext:           ext,
+		header:        header,
+		compression:   compression,
+		open:          make(map[string]*outputFile),
+	}
+}
+
+// Writer - a writer for the records of a layer
+func (d *outputDir) Writer(profile string, layer string) recordWriter {
+	return &dirWriter{d, profile, layer}
+}
+
+// Close - flush and close all open files
+func (d *outputDir) Close() error {
+	var err error
+	for _, f := range d.open {
+		if cerr := f.close(); err == nil {
+			err = cerr
+		}
+	}
+	d.open = make(map[string]*outputFile)
+	return err
+}
+
+// dirWriter - writes the records of a layer to an output directory
+type dirWriter struct {
+	dir     *outputDir
+	profile string
+	layer   string
+}
+
+func (w *dirWriter) WriteRecord(kind string, id int64, record []byte) {
+	d := w.dir
+	name := d.fileName(w.profile, w.layer, kind, id)
+	size := int64(len(record) + 1)
+
+	// rotate files which are full
+	f, ok := d.open[name]
+	if ok && d.full(f, size) {
+		if err := f.close(); err != nil {
+			fatal(err)
+		}
+		ok = false
+	}
+	if !ok {
+		f = d.create(name)
+	}
+	f.write(record)
+}
+
+// the name of the file a record is written to, excluding rotation
+// eg. 'venues-node-s003' for the 'venues' profile split by type
+func (d *outputDir) fileName(profile string, layer string, kind string, id int64) string {
+	var parts []string
+	if profile != "" {
+		parts = append(parts, profile)
+	}
+	switch d.split {
+	case splitType:
+		parts = append(parts, kind)
+	case splitLayer:
+		if layer == "" {
+			layer = "default"
+		}
+		parts = append(parts, layer)
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "records")
+	}
+	if d.shards > 1 {
+		parts = append(parts, fmt.Sprintf("s%03d", shardOf(id, d.shards)))
+	}
+	return strings.Join(parts, "-")
+}
+
+// check if writing size more bytes would exceed the rotation limits
+func (d *outputDir) full(f *outputFile, size int64) bool {
+	if d.rotateRecords > 0 && f.Records >= d.rotateRecords {
+		return true
+	}
+	return d.rotateBytes > 0 && f.Records > 0 && f.Bytes+size > d.rotateBytes
+}
+
+// create the next file for a name, rotated files are numbered from 1
+func (d *outputDir) create(name string) *outputFile {
+	seq := 1
+	if prev, ok := d.open[name]; ok {
+		seq = prev.seq + 1
+	}
+	filename := name
+	if d.rotateRecords > 0 || d.rotateBytes > 0 {
+		filename = fmt.Sprintf("%s-%05d", name, seq)
+	}
+	filename += d.ext + compressionExt(d.compression)
+
+	f := openOutputFile(filepath.Join(d.path, filename), d.compression, d.header)
+	f.seq = seq
+	d.open[name] = f
+	d.Files = append(d.Files, f)
+	return f
+}
+
+// write a record and count it
+func (f *outputFile) write(record []byte) {
+	if _, err := f.stream.Write(record); err != nil {
+		fatal(err)
+	}
+	if err := f.stream.WriteByte('\n'); err != nil {
+		fatal(err)
+	}
+	f.Records++
+	f.Bytes += int64(len(record) + 1)
+}
+
+func (f *outputFile) close() error {
+	if f.stream == nil {
+		return nil
+	}
+	err := f.stream.Close()
+	f.stream = nil
+	return err
+}
+
+// shardOf - the shard an element ID is assigned to, using an FNV-1a hash
+// of the ID so the assignment is the same for every run
+func shardOf(id int64, shards int) int {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(id))
+	hash := fnv.New32a()
+	hash.Write(buf)
+	return int(hash.Sum32() % uint32(shards))
+}
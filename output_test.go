@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &streamWriter{&buf}
+	w.WriteRecord("node", 1, []byte(`{"id":1}`))
+	w.WriteRecord("way", 2, []byte(`{"id":2}`))
+	assert.Equal(t, "{\"id\":1}\n{\"id\":2}\n", buf.String())
+}
+
+func TestOutputDirFileName(t *testing.T) {
+	dir := newOutputDir(t.TempDir(), splitNone, 1, 0, 0, ".json", nil, compressNone)
+	assert.Equal(t, "records", dir.fileName("", "", "node", 1))
+	assert.Equal(t, "venues", dir.fileName("venues", "cafes", "node", 1))
+
+	dir.split = splitType
+	assert.Equal(t, "node", dir.fileName("", "cafes", "node", 1))
+	assert.Equal(t, "venues-way", dir.fileName("venues", "cafes", "way", 1))
+
+	dir.split = splitLayer
+	assert.Equal(t, "cafes", dir.fileName("", "cafes", "node", 1))
+	assert.Equal(t, "default", dir.fileName("", "", "node", 1))
+
+	dir.shards = 16
+	assert.Equal(t, fmt.Sprintf("cafes-s%03d", shardOf(1, 16)), dir.fileName("", "cafes", "node", 1))
+}
+
+func TestShardOf(t *testing.T) {
+	counts := make([]int, 4)
+	for id := int64(0); id < 1000; id++ {
+		shard := shardOf(id, 4)
+		assert.Equal(t, shard, shardOf(id, 4))
+		counts[shard]++
+	}
+
+	// every shard is used
+	for _, count := range counts {
+		assert.True(t, count > 100)
+	}
+}
+
+func TestOutputDirRotateRecords(t *testing.T) {
+	path := t.TempDir()
+	dir := newOutputDir(path, splitType, 1, 2, 0, ".json", nil, compressNone)
+	w := dir.Writer("", "")
+	for id := int64(1); id <= 5; id++ {
+		w.WriteRecord("node", id, []byte("{}"))
+	}
+	w.WriteRecord("way", 1, []byte("{}"))
+	dir.Close()
+
+	var names []string
+	var records []int64
+	for _, f := range dir.Files {
+		names = append(names, filepath.Base(f.Path))
+		records = append(records, f.Records)
+	}
+	assert.Equal(t, []string{"node-00001.json", "node-00002.json", "node-00003.json", "way-00001.json"}, names)
+	assert.Equal(t, []int64{2, 2, 1, 1}, records)
+
+	data, err := ioutil.ReadFile(filepath.Join(path, "node-00002.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, "{}\n{}\n", string(data))
+}
+
+func TestOutputDirRotateBytes(t *testing.T) {
+	dir := newOutputDir(t.TempDir(), splitNone, 1, 0, 10, ".json", nil, compressNone)
+	w := dir.Writer("", "")
+	w.WriteRecord("node", 1, []byte("1234"))        // 5 bytes
+	w.WriteRecord("node", 2, []byte("1234"))        // 10 bytes
+	w.WriteRecord("node", 3, []byte("1234"))        // rotated
+	w.WriteRecord("node", 4, []byte("12345678901")) // larger than the limit, rotated
+	dir.Close()
+
+	assert.Len(t, dir.Files, 3)
+	assert.Equal(t, int64(10), dir.Files[0].Bytes)
+	assert.Equal(t, int64(5), dir.Files[1].Bytes)
+	assert.Equal(t, int64(12), dir.Files[2].Bytes)
+}
+
+func TestOutputDirHeader(t *testing.T) {
+	path := t.TempDir()
+	dir := newOutputDir(path, splitNone, 1, 0, 10, ".csv", []byte("id"), compressNone)
+	w := dir.Writer("", "")
+	w.WriteRecord("node", 1, []byte("1234")) // 8 bytes including the header
+	w.WriteRecord("node", 2, []byte("1234")) // rotated
+	dir.Close()
+
+	assert.Len(t, dir.Files, 2)
+	assert.Equal(t, int64(8), dir.Files[0].Bytes)
+	assert.Equal(t, int64(1), dir.Files[0].Records)
+	data, err := ioutil.ReadFile(filepath.Join(path, "records-00002.csv"))
+	assert.Nil(t, err)
+	assert.Equal(t, "id\n1234\n", string(data))
+}
@@ -2,16 +2,16 @@ package main
 
 import (
 	"encoding/binary"
-	"encoding/json"
 	"flag"
-	"fmt"
 	"io"
 	"log"
 	"math"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	geo "github.com/paulmach/go.geo"
 	"github.com/qedus/osmpbf"
@@ -19,45 +19,314 @@ import (
 )
 
 type settings struct {
-	PbfPath    string
-	LevedbPath string
-	Tags       map[string][]string
-	BatchSize  int
-	WayNodes   bool
+	PbfPaths      []string
+	LevedbPath    string
+	Profiles      []*profile
+	Output        string
+	Split         string
+	Shards        int
+	RotateRecords int64
+	RotateBytes   int64
+	Compress      string
+	BatchSize     int
+	WayNodes      bool
+	KeepCache     bool
+	Progress      time.Duration
+	Manifest      string
+	Missing       string
+	MarkPartial   bool // mark relations printed without some member ways, unless -missing is the default
+	Report        string
+	Metadata      map[string]bool
+	Filter        *metadataFilter
+	Project       *tagProjection
+	Transform     *tagTransform
+	Normalise     bool
+	Schema        int
+	Precision     int
+	Format        string
+	ESIndex       string
+	Pelias        *peliasConfig
+	Columns       []csvColumn
+	PGTags        string
+	FGBIndex      bool
+	Geometry      string
+	Simplify      float64
 }
 
-var emptyLatLons = make([]map[string]string, 0)
-
 func getSettings() settings {
 
 	// command line flags
-	leveldbPath := flag.String("leveldb", "/tmp", "path to leveldb directory")
+	configPath := flag.String("config", "", "path to a JSON config of named layers and flag defaults, flags on the command line take precedence")
+	leveldbPath := flag.String("leveldb", "", "path to leveldb directory (default: a new directory in the system temp dir)")
 	tagList := flag.String("tags", "", "comma-separated list of valid tags, group AND conditions with a +")
 	batchSize := flag.Int("batch", 50000, "batch leveldb writes in batches of this size")
 	wayNodes := flag.Bool("waynodes", false, "should the lat/lons of nodes belonging to ways be printed")
+	simplify := flag.Float64("simplify", 0, "simplify printed way nodes and geometries, dropping nodes within this many metres of the simplified line (Douglas-Peucker), 0 to disable")
+	geometry := flag.String("geometry", geometryNone, "print the geometry of ways and relations as a single field: wkt, wkb-hex, polyline6 or geojson")
+	keepCache := flag.Bool("keep-cache", false, "do not remove the leveldb cache when the run ends")
+	progressInterval := flag.Duration("progress", 30*time.Second, "interval between progress reports on stderr, 0 to disable")
+	manifestPath := flag.String("manifest", "", "write a JSON description of the run to this path")
+	missingPolicy := flag.String("missing", missingDrop, "how to handle ways and relations with missing references: drop, skip-refs or fail")
+	reportPath := flag.String("missing-report", "", "write an NDJSON report of elements with missing references to this path")
+	newerThan := flag.String("newer-than", "", "only match elements edited at or after this date (YYYY-MM-DD or RFC 3339)")
+	olderThan := flag.String("older-than", "", "only match elements edited before this date (YYYY-MM-DD or RFC 3339)")
+	users := flag.String("user", "", "only match elements last edited by one of these comma-separated users")
+	uids := flag.String("uid", "", "only match elements last edited by one of these comma-separated user IDs")
+	minVersion := flag.Int("min-version", 0, "only match elements with at least this version")
+	outputPath := flag.String("output", "", "write records to files in this directory instead of stdout, a single file when it ends in .gz, .zst or .zstd, or the file written by -format=gpkg or fgb")
+	split := flag.String("split", splitNone, "split the files in the output directory by: none, type or layer")
+	shards := flag.Int("shards", 1, "shard the files in the output directory by a hash of the element ID")
+	rotateRecords := flag.Int64("rotate-records", 0, "start a new file in the output directory after this many records, 0 to disable")
+	rotateBytes := flag.Int64("rotate-bytes", 0, "start a new file in the output directory before it exceeds this many bytes, 0 to disable")
+	compress := flag.String("compress", "", "compress output with: none, gzip or zstd (default: by output file extension)")
+	keepTags := flag.String("keep-tags", "", "comma-separated list of glob patterns, only print tags with matching keys")
+	dropTags := flag.String("drop-tags", "", "comma-separated list of glob patterns, do not print tags with matching keys")
+	normalise := flag.Bool("normalise-tags", false, "match elements against their trimmed tags, as they are printed")
+	transformPath := flag.String("transform", "", "path to a JSON config of transforms applied to the tags of each record")
+	metadataList := flag.String("metadata", "", "comma-separated list of metadata fields to print: version,timestamp,changeset,uid,user,visible")
+	schema := flag.Int("schema", schemaV1, "output schema version, 1 or 2 (numeric coordinates), see schema/record.schema.json")
+	precision := flag.Int("precision", defaultPrecision, "decimal places of coordinates in schema 2, esbulk, pelias, csv and tsv output")
+	format := flag.String("format", formatJSON, "output format: json, esbulk (elasticsearch _bulk API), pelias (pelias documents), gpkg (GeoPackage file), fgb (FlatGeobuf), csv, tsv or pgcopy (PostgreSQL COPY)")
+	esIndex := flag.String("es-index", "pbf2json", "name of the elasticsearch index written to by esbulk output")
+	peliasPath := flag.String("pelias-config", "", "path to a JSON config of the layer mapping and categories of pelias documents")
+	columnList := flag.String("columns", "", "comma-separated list of the element fields and tags printed by csv and tsv output (default \""+defaultColumns+"\"), or the columns of fgb output")
+	fgbIndex := flag.Bool("fgb-index", true, "write the packed hilbert R-tree spatial index of fgb output")
+	pgTags := flag.String("pg-tags", pgTagsJSONB, "type of the tags column of pgcopy output: jsonb or hstore")
+	pgTable := flag.String("pg-table", "pbf2json", "name of the table in the DDL printed by -pg-ddl")
+	pgDDL := flag.Bool("pg-ddl", false, "print the CREATE TABLE statement for pgcopy output and exit")
 
 	flag.Parse()
 	args := flag.Args()
 
+	// load defaults and profiles from the config file
+	// note: tags set on the command line replace the profiles in the config file
+	var profiles []*profile
+	if len(*configPath) > 0 {
+		cliTags := *tagList
+		fileConfig, err := loadConfig(*configPath)
+		if err != nil {
+			fatal(err)
+		}
+		if err := fileConfig.applyFlags(flag.CommandLine); err != nil {
+			fatal(err)
+		}
+		if len(cliTags) < 1 {
+			if profiles, err = fileConfig.newProfiles(); err != nil {
+				fatal(err)
+			}
+		}
+	}
+
+	// invalid tags column type
+	switch *pgTags {
+	case pgTagsJSONB, pgTagsHstore:
+	default:
+		fatal("invalid -pg-tags, expected one of: jsonb, hstore")
+	}
+
+	// print the table definition for pgcopy output, no input is required
+	if *pgDDL {
+		if _, err := os.Stdout.WriteString(pgCreateTable(*pgTable, *pgTags)); err != nil {
+			fatal(err)
+		}
+		exit(0)
+	}
+
 	if len(args) < 1 {
-		log.Fatal("invalid args, you must specify a PBF file")
+		fatal("invalid args, you must specify a PBF file (or '-' for stdin)")
+	}
+
+	// stdin can only be read once
+	stdin := 0
+	for _, path := range args {
+		if path == "-" {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		fatal("invalid args, stdin '-' may only be specified once")
+	}
+
+	// invalid missing reference policy
+	switch *missingPolicy {
+	case missingDrop, missingSkipRefs, missingFail:
+	default:
+		fatal("invalid -missing policy, expected one of: drop, skip-refs, fail")
+	}
+
+	// relations printed without some of their member ways are only marked
+	// as partial when a policy is chosen, the default output is unchanged
+	markPartial := false
+	flag.Visit(func(f *flag.Flag) {
+		markPartial = markPartial || f.Name == "missing"
+	})
+
+	// invalid output options
+	switch *split {
+	case splitNone, splitType, splitLayer:
+	default:
+		fatal("invalid -split, expected one of: none, type, layer")
+	}
+	if *shards < 1 {
+		fatal("invalid -shards, must be at least 1")
+	}
+	if len(*outputPath) < 1 && (*split != splitNone || *shards > 1 || *rotateRecords > 0 || *rotateBytes > 0) {
+		if *format == formatESBulk && *rotateBytes > 0 {
+			fatal("invalid args, esbulk output written to stdout is a single request body, use -output with -rotate-bytes to write requests of a maximum size")
+		}
+		fatal("invalid args, -split, -shards and -rotate-* require an -output directory")
+	}
+
+	// invalid compression method
+	switch *compress {
+	case "", compressNone, compressGzip, compressZstd:
+	default:
+		fatal("invalid -compress, expected one of: none, gzip, zstd")
+	}
+
+	// invalid output format
+	switch *format {
+	case formatJSON, formatESBulk, formatPelias, formatGPKG, formatFGB, formatCSV, formatTSV, formatPGCopy:
+	default:
+		fatal("invalid -format, expected one of: json, esbulk, pelias, gpkg, fgb, csv, tsv, pgcopy")
+	}
+	if (*format == formatGPKG || *format == formatFGB) && (*split != splitNone || *shards > 1 || *rotateRecords > 0 || *rotateBytes > 0 || (*compress != "" && *compress != compressNone)) {
+		fatalf("invalid args, -split, -shards, -rotate-* and -compress are not supported with -format=%s", *format)
+	}
+
+	// a compressed -output is a single file rather than a directory
+	if compressionFor(*outputPath, "") != compressNone {
+		if *format == formatGPKG || *format == formatFGB {
+			fatalf("invalid args, -format=%s output can not be compressed, remove the extension from -output=%s", *format, *outputPath)
+		}
+		if *split != splitNone || *shards > 1 || *rotateRecords > 0 || *rotateBytes > 0 {
+			fatalf("invalid args, -output=%s is a compressed file, -split, -shards and -rotate-* require an -output directory", *outputPath)
+		}
+	}
+
+	// invalid simplification tolerance
+	if *simplify < 0 {
+		fatal("invalid -simplify, the tolerance must not be negative")
+	}
+
+	// invalid geometry format
+	switch *geometry {
+	case geometryNone, geometryWKT, geometryWKBHex, geometryPolyline6, geometryGeoJSON:
+	default:
+		fatal("invalid -geometry, expected one of: wkt, wkb-hex, polyline6, geojson")
+	}
+	if *geometry != geometryNone && *format != formatJSON {
+		fatal("invalid args, -geometry is only supported with -format=json")
+	}
+
+	// invalid pelias config
+	pelias, err := loadPeliasConfig(*peliasPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	// invalid csv columns
+	// note: without -columns fgb output has a single json column of the tags
+	var columns []csvColumn
+	if len(*columnList) > 0 || *format != formatFGB {
+		if columns, err = parseColumns(*columnList); err != nil {
+			fatal(err)
+		}
+	}
+
+	// invalid output schema
+	if *schema != schemaV1 && *schema != schemaV2 {
+		fatal("invalid -schema, expected one of: 1, 2")
+	}
+	if *precision < 0 || *precision > 15 {
+		fatal("invalid -precision, must be between 0 and 15")
+	}
+
+	// invalid metadata fields
+	metadata, err := parseMetadataFields(*metadataList)
+	if err != nil {
+		fatal(err)
+	}
+
+	// invalid metadata filters
+	filter, err := newMetadataFilter(*newerThan, *olderThan, *users, *uids, *minVersion)
+	if err != nil {
+		fatal(err)
+	}
+
+	// invalid tag patterns
+	projection, err := newTagProjection(*keepTags, *dropTags)
+	if err != nil {
+		fatal(err)
+	}
+
+	// invalid transform config
+	transform, err := loadTransform(*transformPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	// a single unnamed layer matching the tag conditions
+	if len(profiles) == 0 && len(*tagList) > 0 {
+		profiles = append(profiles, &profile{Layers: []*layer{{
+			Tags:  parseTagConditions(*tagList),
+			Types: map[string]bool{"node": true, "way": true, "relation": true},
+		}}})
 	}
 
 	// invalid tags
-	if len(*tagList) < 1 {
-		log.Fatal("Nothing to do, you must specify tags to match against")
+	if len(profiles) == 0 {
+		fatal("Nothing to do, you must specify tags to match against")
 	}
 
-	// parse tag conditions
-	conditions := make(map[string][]string)
-	for _, group := range strings.Split(*tagList, ",") {
-		conditions[group] = strings.Split(group, "+")
+	// a GeoPackage can not be written to stdout
+	if *format == formatGPKG {
+		for _, p := range profiles {
+			for _, l := range p.Layers {
+				if l.Output == "-" || (l.Output == "" && len(*outputPath) < 1) {
+					fatal("invalid args, -format=gpkg requires an -output file")
+				}
+			}
+		}
 	}
 
 	// fmt.Print(conditions, len(conditions))
 	// os.Exit(1)
 
-	return settings{args[0], *leveldbPath, conditions, *batchSize, *wayNodes}
+	return settings{
+		PbfPaths:      args,
+		LevedbPath:    *leveldbPath,
+		Profiles:      profiles,
+		Output:        *outputPath,
+		Split:         *split,
+		Shards:        *shards,
+		RotateRecords: *rotateRecords,
+		RotateBytes:   *rotateBytes,
+		Compress:      *compress,
+		BatchSize:     *batchSize,
+		WayNodes:      *wayNodes,
+		KeepCache:     *keepCache,
+		Progress:      *progressInterval,
+		Manifest:      *manifestPath,
+		Missing:       *missingPolicy,
+		MarkPartial:   markPartial,
+		Report:        *reportPath,
+		Metadata:      metadata,
+		Filter:        filter,
+		Project:       projection,
+		Transform:     transform,
+		Normalise:     *normalise,
+		Schema:        *schema,
+		Precision:     *precision,
+		Format:        *format,
+		ESIndex:       *esIndex,
+		Pelias:        pelias,
+		Columns:       columns,
+		PGTags:        *pgTags,
+		FGBIndex:      *fgbIndex,
+		Geometry:      *geometry,
+		Simplify:      *simplify,
+	}
 }
 
 func main() {
@@ -65,9 +334,24 @@ func main() {
 	// configuration
 	config := getSettings()
 
-	// open pbf file
-	file := openFile(config.PbfPath)
-	defer file.Close()
+	// run cleanup handlers on exit, a signal stops the run at the next element
+	handleSignals()
+	defer runCleanups()
+
+	// select and lock a cache directory, it is removed when the run ends
+	cache := openCacheDir(config.LevedbPath, config.KeepCache)
+	addCleanup(cache.Close)
+
+	// open pbf files, '-' reads from stdin
+	var files []*os.File
+	for _, path := range config.PbfPaths {
+		file := openInput(path, cache.Path)
+		defer file.Close()
+		files = append(files, file)
+	}
+
+	// refuse to reuse a cache generated from different files
+	cache.Claim(config.PbfPaths, files)
 
 	// perform two passes over the file, on the first pass
 	// we record a bitmask of the interesting elements in the
@@ -77,69 +361,173 @@ func main() {
 	var masks = NewBitmaskMap()
 
 	// set up leveldb connection
-	var db = openLevelDB(config.LevedbPath)
-	defer db.Close()
+	// note: it must be closed before the cache directory is removed
+	var db = openLevelDB(cache.Path)
+	addCleanup(db.Close)
 
-	// === first pass (indexing) ===
-	idxDecoder := osmpbf.NewDecoder(file)
-	err := idxDecoder.Start(runtime.GOMAXPROCS(-1)) // use several goroutines for faster decoding
-	if err != nil {
-		log.Fatal(err)
+	// collect statistics, reporting progress of each pass on stderr
+	var stats = newStats(newProgress(config.Progress))
+
+	// describe the run in a machine-readable manifest
+	var manifest *manifest
+	if len(config.Manifest) > 0 {
+		manifest = newManifest(config.PbfPaths, files, stats)
 	}
 
-	// index target IDs in bitmasks
-	index(idxDecoder, masks, config)
+	// when several (possibly overlapping) files are provided they are
+	// merged, each element is only printed once, from its newest copy
+	var merge *merger
+	if len(files) > 1 {
+		merge = newMerger(db, config.BatchSize, stats)
+	}
+
+	// === first pass (indexing) ===
+	stats.StartPass("index", files)
+	for i, file := range files {
+		merge.setFile(i)
+
+		// index target IDs in bitmasks
+		index(startDecoder(file, stats), masks, config, merge, stats)
+	}
+	merge.flush()
+	stats.FinishPass()
 
 	// no-op if no relation members of type 'way' present in mask
 	if !masks.RelWays.Empty() {
 		// === potential second pass (indexing) to index members of relations ===
-		file.Seek(io.SeekStart, 0) // rewind file
-		idxRelationsDecoder := osmpbf.NewDecoder(file)
-		err = idxRelationsDecoder.Start(runtime.GOMAXPROCS(-1)) // use several goroutines for faster decoding
-		if err != nil {
-			log.Fatal(err)
+		stats.StartPass("indexRelationMembers", files)
+		for _, file := range files {
+			// index relation member IDs in bitmasks
+			indexRelationMembers(startDecoder(file, stats), masks, config, stats)
 		}
+		stats.FinishPass()
+	}
+
+	// === potential third pass (caching) when merging several files ===
+	// note: a single file is cached during the final pass, this is not
+	// possible when merging because ways may reference nodes in later files
+	if merge != nil {
+		stats.StartPass("cacheMembers", files)
+		for i, file := range files {
+			merge.setFile(i)
 
-		// index relation member IDs in bitmasks
-		indexRelationMembers(idxRelationsDecoder, masks, config)
+			// write way refs and relation members to leveldb
+			cacheMembers(startDecoder(file, stats), masks, db, config, merge, stats)
+		}
+		merge.flush()
+		stats.FinishPass()
 	}
 
+	// the manifest lists the files written, so it is written once the outputs
+	// are closed (handlers run in reverse order), when the run completed
+	var output *outputDir
+	var outputs []*outputFile
+	completed := false
+	if manifest != nil {
+		addCleanup(func() error {
+			if !completed || cleanupFailed() {
+				return nil
+			}
+			if output != nil {
+				manifest.Outputs = append(manifest.Outputs, output.Files...)
+			}
+			manifest.Outputs = append(manifest.Outputs, outputs...)
+			return manifest.Write(config.Manifest)
+		})
+	}
+
+	// open the output of each layer
+	// note: all layers share an encoder, records are printed from a single goroutine
+	switch config.Format {
+	case formatGPKG:
+		outputs = openGeoPackages(config.Profiles, config.Output, config.WayNodes)
+	case formatFGB:
+		outputs = openFlatGeobufs(config.Profiles, config.Output, config.Columns, config.FGBIndex, cache.Path)
+	default:
+		format := newRecordFormat(config)
+
+		// a compressed -output is a single file, otherwise it is a directory
+		path := "-"
+		if compressionFor(config.Output, "") != compressNone {
+			path = config.Output
+		} else if len(config.Output) > 0 {
+			output = newOutputDir(config.Output, config.Split, config.Shards, config.RotateRecords, config.RotateBytes, format.Ext(), format.Header(), compressionFor(config.Output, config.Compress))
+			addCleanup(output.Close)
+		}
+		outputs = openLayerOutputs(config.Profiles, output, path, config.Compress, format)
+	}
+
+	// list elements with missing references
+	var report = openFailureReport(config.Report)
+	addCleanup(report.Close)
+
 	// === final pass (printing json) ===
-	file.Seek(io.SeekStart, 0) // rewind file
-	decoder := osmpbf.NewDecoder(file)
-	err = decoder.Start(runtime.GOMAXPROCS(-1)) // use several goroutines for faster decoding
-	if err != nil {
-		log.Fatal(err)
+	stats.StartPass("print", files)
+	for i, file := range files {
+		merge.setFile(i)
+
+		// print json
+		print(startDecoder(file, stats), masks, db, config, merge, stats, report)
 	}
+	stats.FinishPass()
+
+	// summarise the run
+	stats.Measure(cache.Path, masks)
+	stats.Print()
 
-	// print json
-	print(decoder, masks, db, config)
+	// close the outputs and write the manifest, fail if the output could not
+	// be flushed or a signal was caught
+	completed = true
+	exit(0)
+}
+
+// rewind file and start decoding it from the beginning
+func startDecoder(file *os.File, stats *stats) *osmpbf.Decoder {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		fatal(err)
+	}
+	decoder := osmpbf.NewDecoder(stats.Reader(file))
+	err := decoder.Start(runtime.GOMAXPROCS(-1)) // use several goroutines for faster decoding
+	if err != nil {
+		fatal(err)
+	}
+	return decoder
 }
 
-func index(d *osmpbf.Decoder, masks *BitmaskMap, config settings) {
+func index(d *osmpbf.Decoder, masks *BitmaskMap, config settings, merge *merger, stats *stats) {
 	for {
+		checkInterrupted()
 		if v, err := d.Decode(); err == io.EOF {
 			break
 		} else if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		} else {
+			stats.Element()
 			switch v := v.(type) {
 
 			case *osmpbf.Node:
-				if hasTags(v.Tags) && containsValidTags(v.Tags, config.Tags) {
+				stats.CountSeen("node")
+				if len(matchElement("node", v.Tags, config)) > 0 && config.Filter.Match(v.Info) {
+					stats.CountMatched("node")
 					masks.Nodes.Insert(v.ID)
+					merge.claim(ownerPrefix, kindNode, v.ID, v.Info.Version)
 				}
 
 			case *osmpbf.Way:
-				if hasTags(v.Tags) && containsValidTags(v.Tags, config.Tags) {
+				stats.CountSeen("way")
+				if len(matchElement("way", v.Tags, config)) > 0 && config.Filter.Match(v.Info) {
+					stats.CountMatched("way")
 					masks.Ways.Insert(v.ID)
+					merge.claim(ownerPrefix, kindWay, v.ID, v.Info.Version)
 					for _, nodeid := range v.NodeIDs {
 						masks.WayRefs.Insert(nodeid)
 					}
 				}
 
 			case *osmpbf.Relation:
-				if hasTags(v.Tags) && containsValidTags(v.Tags, config.Tags) {
+				stats.CountSeen("relation")
+				if len(matchElement("relation", v.Tags, config)) > 0 && config.Filter.Match(v.Info) {
+					stats.CountMatched("relation")
 
 					// record a count of which type of members
 					// are present in the relation
@@ -150,10 +538,12 @@ func index(d *osmpbf.Decoder, masks *BitmaskMap, config settings) {
 
 					// skip relations which contain 0 ways
 					if count[1] == 0 {
+						stats.CountSkipped(skipRelationWithoutWays)
 						continue
 					}
 
 					masks.Relations.Insert(v.ID)
+					merge.claim(ownerPrefix, kindRelation, v.ID, v.Info.Version)
 					for _, member := range v.Members {
 						switch member.Type {
 						case 0: // node
@@ -170,13 +560,15 @@ func index(d *osmpbf.Decoder, masks *BitmaskMap, config settings) {
 	}
 }
 
-func indexRelationMembers(d *osmpbf.Decoder, masks *BitmaskMap, config settings) {
+func indexRelationMembers(d *osmpbf.Decoder, masks *BitmaskMap, config settings, stats *stats) {
 	for {
+		checkInterrupted()
 		if v, err := d.Decode(); err == io.EOF {
 			break
 		} else if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		} else {
+			stats.Element()
 			switch v := v.(type) {
 			case *osmpbf.Way:
 				if masks.RelWays.Has(v.ID) {
@@ -201,18 +593,76 @@ func indexRelationMembers(d *osmpbf.Decoder, masks *BitmaskMap, config settings)
 	}
 }
 
-func print(d *osmpbf.Decoder, masks *BitmaskMap, db *leveldb.DB, config settings) {
+// write way refs and relation members to leveldb, keeping the newest copy of
+// each element, and withdraw elements which have a newer unmatched copy.
+func cacheMembers(d *osmpbf.Decoder, masks *BitmaskMap, db *leveldb.DB, config settings, merge *merger, stats *stats) {
+
+	batch := new(leveldb.Batch)
+
+	for {
+		checkInterrupted()
+		if v, err := d.Decode(); err == io.EOF {
+			break
+		} else if err != nil {
+			fatal(err)
+		} else {
+			stats.Element()
+			switch v := v.(type) {
+
+			case *osmpbf.Node:
+				if masks.WayRefs.Has(v.ID) || masks.RelNodes.Has(v.ID) {
+					if merge.claim(cachedPrefix, kindNode, v.ID, v.Info.Version) {
+						cacheQueueNode(batch, v)
+						stats.CountCached()
+					}
+				}
+				if masks.Nodes.Has(v.ID) {
+					merge.supersede(kindNode, v.ID, v.Info.Version)
+				}
+
+			case *osmpbf.Way:
+				if masks.RelWays.Has(v.ID) {
+					if merge.claim(cachedPrefix, kindWay, v.ID, v.Info.Version) {
+						cacheQueueWay(batch, v)
+						stats.CountCached()
+					}
+				}
+				if masks.Ways.Has(v.ID) {
+					merge.supersede(kindWay, v.ID, v.Info.Version)
+				}
+
+			case *osmpbf.Relation:
+				if masks.Relations.Has(v.ID) {
+					merge.supersede(kindRelation, v.ID, v.Info.Version)
+				}
+			}
+
+			// write in batches
+			if batch.Len() > config.BatchSize {
+				cacheFlush(db, batch, true)
+			}
+		}
+	}
+
+	if batch.Len() > 0 {
+		cacheFlush(db, batch, true)
+	}
+}
+
+func print(d *osmpbf.Decoder, masks *BitmaskMap, db *leveldb.DB, config settings, merge *merger, stats *stats, report *failureReport) {
 
 	batch := new(leveldb.Batch)
 	finishedNodes := false
 	finishedWays := false
 
 	for {
+		checkInterrupted()
 		if v, err := d.Decode(); err == io.EOF {
 			break
 		} else if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		} else {
+			stats.Element()
 			switch v := v.(type) {
 
 			case *osmpbf.Node:
@@ -220,11 +670,13 @@ func print(d *osmpbf.Decoder, masks *BitmaskMap, db *leveldb.DB, config settings
 				// ----------------
 				// write to leveldb
 				// note: only write way refs and relation member nodes
+				// note: already cached in a previous pass when merging files
 				// ----------------
-				if masks.WayRefs.Has(v.ID) || masks.RelNodes.Has(v.ID) {
+				if merge == nil && (masks.WayRefs.Has(v.ID) || masks.RelNodes.Has(v.ID)) {
 
 					// write in batches
 					cacheQueueNode(batch, v)
+					stats.CountCached()
 					if batch.Len() > config.BatchSize {
 						cacheFlush(db, batch, true)
 					}
@@ -232,11 +684,20 @@ func print(d *osmpbf.Decoder, masks *BitmaskMap, db *leveldb.DB, config settings
 
 				// bitmask indicates if this is a node of interest
 				// if so, print it
-				if masks.Nodes.Has(v.ID) {
-
-					// trim tags
-					v.Tags = trimTags(v.Tags)
-					onNode(v)
+				if masks.Nodes.Has(v.ID) && merge.owns(kindNode, v.ID) {
+
+					// trim and transform tags
+					tags := cleanTags("node", v.ID, v.Tags, config)
+					meta := newMetadata(config.Metadata, v.Info)
+
+					// print once for each profile matched
+					for _, layer := range matchElement("node", v.Tags, config) {
+						if onNode(layer, v, layerTags(tags, config, layer), meta) {
+							stats.CountEmitted("node")
+						} else {
+							stats.CountSkipped(skipFormat)
+						}
+					}
 				}
 
 			case *osmpbf.Way:
@@ -256,11 +717,13 @@ func print(d *osmpbf.Decoder, masks *BitmaskMap, db *leveldb.DB, config settings
 				// ----------------
 				// write to leveldb
 				// note: only write relation member ways
+				// note: already cached in a previous pass when merging files
 				// ----------------
-				if masks.RelWays.Has(v.ID) {
+				if merge == nil && masks.RelWays.Has(v.ID) {
 
 					// write in batches
 					cacheQueueWay(batch, v)
+					stats.CountCached()
 					if batch.Len() > config.BatchSize {
 						cacheFlush(db, batch, true)
 					}
@@ -268,26 +731,52 @@ func print(d *osmpbf.Decoder, masks *BitmaskMap, db *leveldb.DB, config settings
 
 				// bitmask indicates if this is a way of interest
 				// if so, print it
-				if masks.Ways.Has(v.ID) {
+				if masks.Ways.Has(v.ID) && merge.owns(kindWay, v.ID) {
 
 					// lookup from leveldb
-					latlons, err := cacheLookupNodes(db, v)
-
-					// skip ways which fail to denormalize
-					if err != nil {
-						break
+					latlons, missingNodes := cacheLookupNodes(db, v)
+
+					// skip ways which fail to denormalize, unless
+					// the missing reference policy allows it
+					partial := false
+					if len(missingNodes) > 0 {
+						missing := missingRefs{Nodes: missingNodes}
+						status := applyMissingPolicy(config.Missing, report, "way", v.ID, missing, len(latlons))
+						if status == statusDropped {
+							stats.CountSkipped(skipWayMissingNodes)
+							break
+						}
+						partial = true
+						stats.CountPartial("way")
 					}
 
 					// compute centroid
 					centroid, bounds := computeCentroidAndBounds(latlons)
 
-					// trim tags
-					v.Tags = trimTags(v.Tags)
+					// trim and transform tags
+					tags := cleanTags("way", v.ID, v.Tags, config)
+					meta := newMetadata(config.Metadata, v.Info)
 
-					if config.WayNodes {
-						onWay(v, latlons, centroid, bounds)
-					} else {
-						onWay(v, emptyLatLons, centroid, bounds)
+					// simplify the printed nodes, the centroid and bounds
+					// are computed from all of them
+					if config.Simplify > 0 && (config.WayNodes || config.Geometry != geometryNone) {
+						latlons = simplifyLatLons(latlons, config.Simplify)
+					}
+					var geometry *shape
+					if config.Geometry != geometryNone {
+						geometry = wayShape(latlons)
+					}
+					if !config.WayNodes {
+						latlons = nil
+					}
+
+					// print once for each profile matched
+					for _, layer := range matchElement("way", v.Tags, config) {
+						if onWay(layer, v, layerTags(tags, config, layer), latlons, centroid, bounds, geometry, partial, meta) {
+							stats.CountEmitted("way")
+						} else {
+							stats.CountSkipped(skipFormat)
+						}
 					}
 				}
 
@@ -307,20 +796,32 @@ func print(d *osmpbf.Decoder, masks *BitmaskMap, db *leveldb.DB, config settings
 
 				// bitmask indicates if this is a relation of interest
 				// if so, print it
-				if masks.Relations.Has(v.ID) {
+				if masks.Relations.Has(v.ID) && merge.owns(kindRelation, v.ID) {
 
 					// fetch all latlons for all ways in relation
-					var memberWayLatLons = findMemberWayLatLons(db, v)
+					var memberWayLatLons, missing = findMemberWayLatLons(db, v, config.Missing == missingSkipRefs)
+
+					// apply the missing reference policy
+					partial := false
+					if !missing.Empty() {
+						status := applyMissingPolicy(config.Missing, report, "relation", v.ID, missing, len(memberWayLatLons))
+						partial = status == statusPartial
+					}
 
 					// no ways found, skip relation
 					if len(memberWayLatLons) == 0 {
 						log.Println("[warn] denormalize failed for relation:", v.ID, "no ways found")
+						stats.CountSkipped(skipRelationNoWays)
 						continue
 					}
+					if partial {
+						stats.CountPartial("relation")
+						partial = config.MarkPartial
+					}
 
 					// best centroid and bounds to use
 					var largestArea = 0.0
-					var centroid map[string]string
+					var centroid jsonCentroid
 					var bounds *geo.Bound
 
 					// iterate over each way, selecting the largest way to use
@@ -333,6 +834,7 @@ func print(d *osmpbf.Decoder, masks *BitmaskMap, db *leveldb.DB, config settings
 						// if for any reason we failed to find a valid bounds
 						if nil == wayBounds {
 							log.Println("[warn] failed to calculate bounds for relation member way")
+							stats.CountSkipped(skipMemberWayNoBounds)
 							continue
 						}
 
@@ -349,6 +851,7 @@ func print(d *osmpbf.Decoder, masks *BitmaskMap, db *leveldb.DB, config settings
 					// if for any reason we failed to find a valid bounds
 					if nil == bounds {
 						log.Println("[warn] denormalize failed for relation:", v.ID, "no valid bounds")
+						stats.CountSkipped(skipRelationNoBounds)
 						continue
 					}
 
@@ -358,108 +861,147 @@ func print(d *osmpbf.Decoder, masks *BitmaskMap, db *leveldb.DB, config settings
 					if v.Tags["boundary"] == "administrative" {
 						for _, member := range v.Members {
 							if member.Type == 0 && member.Role == "admin_centre" {
-								if latlons, err := cacheLookupNodeByID(db, member.ID); err == nil {
-									latlons["type"] = "admin_centre"
-									centroid = latlons
+								if latlon, err := cacheLookupNodeByID(db, member.ID); err == nil {
+									centroid = jsonCentroid{Lat: latlon.Lat, Lon: latlon.Lon, Type: "admin_centre"}
 									break
 								}
 							}
 						}
 					}
 
-					// trim tags
-					v.Tags = trimTags(v.Tags)
+					// join the member ways in to polygons and lines
+					var geometry *shape
+					if config.Geometry != geometryNone || (config.WayNodes && (config.Format == formatPGCopy || config.Format == formatGPKG || config.Format == formatFGB)) {
+						geometry = assembleShape(memberWayLatLons)
+					}
+					if geometry != nil && config.Simplify > 0 {
+						geometry.simplify(config.Simplify)
+					}
+
+					// trim and transform tags
+					tags := cleanTags("relation", v.ID, v.Tags, config)
+					meta := newMetadata(config.Metadata, v.Info)
 
-					// print relation
-					onRelation(v, centroid, bounds)
+					// print relation once for each profile matched
+					for _, layer := range matchElement("relation", v.Tags, config) {
+						if onRelation(layer, v, layerTags(tags, config, layer), centroid, bounds, geometry, partial, meta) {
+							stats.CountEmitted("relation")
+						} else {
+							stats.CountSkipped(skipFormat)
+						}
+					}
 				}
 
 			default:
 
-				log.Fatalf("[error] unknown type %T\n", v)
+				fatalf("[error] unknown type %T\n", v)
 
 			}
 		}
 	}
 }
 
-// lookup all latlons for all ways in relation
-func findMemberWayLatLons(db *leveldb.DB, v *osmpbf.Relation) [][]map[string]string {
-	var memberWayLatLons [][]map[string]string
+// lookup all latlons for all ways in relation, member ways with missing
+// nodes are only used when partial ways are allowed
+func findMemberWayLatLons(db *leveldb.DB, v *osmpbf.Relation, partial bool) ([][]latLon, missingRefs) {
+	var memberWayLatLons [][]latLon
+	var missing missingRefs
 
 	for _, mem := range v.Members {
 		if mem.Type == 1 {
 
 			// lookup from leveldb
-			latlons, err := cacheLookupWayNodes(db, mem.ID)
+			latlons, missingNodes, err := cacheLookupWayNodes(db, mem.ID)
 
 			// skip way if it fails to denormalize
 			if err != nil {
-				break
+				missing.Ways = append(missing.Ways, mem.ID)
+				continue
+			}
+			if len(missingNodes) > 0 {
+				missing.Nodes = append(missing.Nodes, missingNodes...)
+				if !partial || len(latlons) == 0 {
+					continue
+				}
 			}
 
 			memberWayLatLons = append(memberWayLatLons, latlons)
 		}
 	}
 
-	return memberWayLatLons
+	return memberWayLatLons, missing
 }
 
-type jsonNode struct {
-	ID   int64             `json:"id"`
-	Type string            `json:"type"`
-	Lat  float64           `json:"lat"`
-	Lon  float64           `json:"lon"`
-	Tags map[string]string `json:"tags"`
+// latLon - the location of a node, entrances include their bitmask values
+type latLon struct {
+	Lat        float64
+	Lon        float64
+	Entrance   uint8 // 0 for nodes which are not an entrance
+	Wheelchair uint8
 }
 
-func onNode(node *osmpbf.Node) {
-	marshall := jsonNode{node.ID, "node", node.Lat, node.Lon, node.Tags}
-	json, _ := json.Marshal(marshall)
-	fmt.Println(string(json))
+// jsonCentroid - the point used to represent an element, the type
+// records where it came from ('entrance' or 'admin_centre') if not computed
+type jsonCentroid struct {
+	Lat  float64
+	Lon  float64
+	Type string
 }
 
-type jsonWay struct {
-	ID   int64             `json:"id"`
-	Type string            `json:"type"`
-	Tags map[string]string `json:"tags"`
-	// NodeIDs   []int64             `json:"refs"`
-	Centroid map[string]string   `json:"centroid"`
-	Bounds   map[string]string   `json:"bounds"`
-	Nodes    []map[string]string `json:"nodes,omitempty"`
+// jsonNode - a node record, see recordEncoder for the JSON encoding
+type jsonNode struct {
+	ID    int64
+	Type  string
+	Layer string
+	Lat   float64
+	Lon   float64
+	Tags  interface{}
+	*jsonMetadata
 }
 
-func jsonBbox(bounds *geo.Bound) map[string]string {
-	// render a North-South-East-West bounding box
-	var bbox = make(map[string]string)
-	bbox["n"] = strconv.FormatFloat(bounds.North(), 'f', 7, 64)
-	bbox["s"] = strconv.FormatFloat(bounds.South(), 'f', 7, 64)
-	bbox["e"] = strconv.FormatFloat(bounds.East(), 'f', 7, 64)
-	bbox["w"] = strconv.FormatFloat(bounds.West(), 'f', 7, 64)
+// print a node, returns false if the output format can not represent it
+func onNode(layer *layer, node *osmpbf.Node, tags interface{}, meta *jsonMetadata) bool {
+	record := jsonNode{node.ID, "node", layer.Name, node.Lat, node.Lon, tags, meta}
+	return layer.out.Node(&record)
+}
 
-	return bbox
+// jsonWay - a way record, see recordEncoder for the JSON encoding
+type jsonWay struct {
+	ID       int64
+	Type     string
+	Layer    string
+	Tags     interface{}
+	Centroid jsonCentroid
+	Bounds   *geo.Bound
+	Geometry *shape   // omitted when nil
+	Nodes    []latLon // omitted when empty
+	Partial  bool
+	*jsonMetadata
 }
 
-func onWay(way *osmpbf.Way, latlons []map[string]string, centroid map[string]string, bounds *geo.Bound) {
-	bbox := jsonBbox(bounds)
-	marshall := jsonWay{way.ID, "way", way.Tags /*, way.NodeIDs*/, centroid, bbox, latlons}
-	json, _ := json.Marshal(marshall)
-	fmt.Println(string(json))
+// print a way, returns false if the output format can not represent it
+func onWay(layer *layer, way *osmpbf.Way, tags interface{}, latlons []latLon, centroid jsonCentroid, bounds *geo.Bound, geometry *shape, partial bool, meta *jsonMetadata) bool {
+	record := jsonWay{way.ID, "way", layer.Name, tags, centroid, bounds, geometry, latlons, partial, meta}
+	return layer.out.Way(&record)
 }
 
+// jsonRelation - a relation record, see recordEncoder for the JSON encoding
 type jsonRelation struct {
-	ID       int64             `json:"id"`
-	Type     string            `json:"type"`
-	Tags     map[string]string `json:"tags"`
-	Centroid map[string]string `json:"centroid"`
-	Bounds   map[string]string `json:"bounds"`
+	ID       int64
+	Type     string
+	Layer    string
+	Tags     interface{}
+	Centroid jsonCentroid
+	Bounds   *geo.Bound
+	Geometry *shape // omitted when nil
+	Partial  bool
+	*jsonMetadata
 }
 
-func onRelation(relation *osmpbf.Relation, centroid map[string]string, bounds *geo.Bound) {
-	bbox := jsonBbox(bounds)
-	marshall := jsonRelation{relation.ID, "relation", relation.Tags, centroid, bbox}
-	json, _ := json.Marshal(marshall)
-	fmt.Println(string(json))
+// print a relation, returns false if the output format can not represent it
+func onRelation(layer *layer, relation *osmpbf.Relation, tags interface{}, centroid jsonCentroid, bounds *geo.Bound, geometry *shape, partial bool, meta *jsonMetadata) bool {
+	record := jsonRelation{relation.ID, "relation", layer.Name, tags, centroid, bounds, geometry, partial, meta}
+	return layer.out.Relation(&record)
 }
 
 // determine if the node is for an entrance
@@ -495,32 +1037,36 @@ func isWheelchairAccessibleNode(node *osmpbf.Node) uint8 {
 }
 
 // decode bytes to a 'latlon' type object
-func bytesToLatLon(data []byte) map[string]string {
-	buf := make([]byte, 8)
-	latlon := make(map[string]string, 4)
+func bytesToLatLon(data []byte) latLon {
+	var buf [8]byte
+	var latlon latLon
 
 	// first 6 bytes are the latitude
-	// buf = append(buf, data[0:6]...)
-	copy(buf, data[:6])
-	lat64 := math.Float64frombits(binary.BigEndian.Uint64(buf[:8]))
-	latlon["lat"] = strconv.FormatFloat(lat64, 'f', 7, 64)
+	copy(buf[:], data[:6])
+	latlon.Lat = roundCoord(math.Float64frombits(binary.BigEndian.Uint64(buf[:])))
 
 	// next 6 bytes are the longitude
-	// buf = append(buf[:0], data[6:12]...)
-	copy(buf, data[6:12])
-	lon64 := math.Float64frombits(binary.BigEndian.Uint64(buf[:8]))
-	latlon["lon"] = strconv.FormatFloat(lon64, 'f', 7, 64)
+	copy(buf[:], data[6:12])
+	latlon.Lon = roundCoord(math.Float64frombits(binary.BigEndian.Uint64(buf[:])))
 
 	// check for the bitmask byte which indicates things like an
 	// entrance and the level of wheelchair accessibility
 	if len(data) > 12 {
-		latlon["entrance"] = fmt.Sprintf("%d", (data[12]&0xC0)>>6)
-		latlon["wheelchair"] = fmt.Sprintf("%d", (data[12]&0x30)>>4)
+		latlon.Entrance = (data[12] & 0xC0) >> 6
+		latlon.Wheelchair = (data[12] & 0x30) >> 4
 	}
 
 	return latlon
 }
 
+// round a coordinate to the 7 decimal places printed, so centroids and
+// bounds are computed from exactly the values printed for way nodes
+func roundCoord(f float64) float64 {
+	var buf [32]byte
+	rounded, _ := strconv.ParseFloat(string(strconv.AppendFloat(buf[:0], f, 'f', 7, 64)), 64)
+	return rounded
+}
+
 // encode a node as bytes (between 12 & 13 bytes used)
 func nodeToBytes(node *osmpbf.Node) (string, []byte) {
 	stringid := strconv.FormatInt(node.ID, 10)
@@ -558,7 +1104,7 @@ func idSliceToBytes(ids []int64) []byte {
 
 func bytesToIDSlice(bytes []byte) []int64 {
 	if len(bytes)%8 != 0 {
-		log.Fatal("invalid byte slice length: not divisible by 8")
+		fatal("invalid byte slice length: not divisible by 8")
 	}
 
 	ids := make([]int64, len(bytes)/8)
@@ -578,12 +1124,12 @@ func wayToBytes(way *osmpbf.Way) (string, []byte) {
 func openFile(filename string) *os.File {
 	// no file specified
 	if len(filename) < 1 {
-		log.Fatal("invalid file: you must specify a pbf path as arg[1]")
+		fatal("invalid file: you must specify a pbf path as arg[1]")
 	}
 	// try to open the file
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	return file
 }
@@ -592,7 +1138,7 @@ func openLevelDB(path string) *leveldb.DB {
 	// try to open the db
 	db, err := leveldb.OpenFile(path, nil)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	return db
 }
@@ -638,13 +1184,91 @@ func containsValidTags(tags map[string]string, group map[string][]string) bool {
 
 // trim leading/trailing spaces from keys and values
 func trimTags(tags map[string]string) map[string]string {
-	trimmed := make(map[string]string)
-	for k, v := range tags {
-		trimmed[strings.TrimSpace(k)] = strings.TrimSpace(v)
-	}
+	trimmed, _ := trimTagsWithConflicts(tags)
 	return trimmed
 }
 
+// trim leading/trailing spaces from keys and values, when several keys trim
+// to the same key the value of a key which needed no trimming is kept,
+// otherwise the value of the key which sorts first. The conflicting keys are
+// returned, sorted.
+func trimTagsWithConflicts(tags map[string]string) (map[string]string, []string) {
+	trimmed := make(map[string]string, len(tags))
+
+	// keys can only collide when at least one of them needs trimming
+	clean := true
+	for k := range tags {
+		if strings.TrimSpace(k) != k {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		for k, v := range tags {
+			trimmed[k] = strings.TrimSpace(v)
+		}
+		return trimmed, nil
+	}
+
+	// visit the keys in order so that collisions resolve deterministically
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	origin := make(map[string]string, len(tags)) // original key of each trimmed key
+	collided := make(map[string]bool)
+	for _, k := range keys {
+		key := strings.TrimSpace(k)
+		if prev, found := origin[key]; found {
+			collided[prev] = true
+			collided[k] = true
+
+			// keep the first key unless this key needed no trimming
+			if k != key {
+				continue
+			}
+		}
+		origin[key] = k
+		trimmed[key] = strings.TrimSpace(tags[k])
+	}
+
+	var conflicts []string
+	for k := range collided {
+		conflicts = append(conflicts, k)
+	}
+	sort.Strings(conflicts)
+	return trimmed, conflicts
+}
+
+// find the layer matching an element in each profile, when normalising the
+// conditions are checked against the tags exactly as they are printed
+func matchElement(kind string, tags map[string]string, config settings) []*layer {
+	if config.Normalise {
+		tags = trimTags(tags)
+	}
+	if !hasTags(tags) {
+		return nil
+	}
+	return matchProfiles(config.Profiles, kind, tags)
+}
+
+// prepare the tags of a matched element for printing, tags are trimmed and transformed
+func cleanTags(kind string, id int64, tags map[string]string, config settings) map[string]string {
+	tags, conflicts := trimTagsWithConflicts(tags)
+	if len(conflicts) > 0 {
+		log.Printf("[warn] trimmed tag keys collide for %s: %d keys: %q\n", kind, id, conflicts)
+	}
+	return config.Transform.Apply(tags)
+}
+
+// select the tags printed by a layer, removing those which should not be printed
+func layerTags(tags map[string]string, config settings, layer *layer) interface{} {
+	tags = layer.Project.Apply(config.Project.Apply(tags))
+	return config.Transform.Split(tags)
+}
+
 // check if a tag list is empty or not
 func hasTags(tags map[string]string) bool {
 	n := len(tags)
@@ -655,53 +1279,47 @@ func hasTags(tags map[string]string) bool {
 }
 
 // select which entrance is preferable
-func selectEntrance(entrances []map[string]string) map[string]string {
+func selectEntrance(entrances []latLon) jsonCentroid {
 
 	// use the mapped entrance location where available
-	var centroid = make(map[string]string)
-	centroid["type"] = "entrance"
+	var centroid = jsonCentroid{Type: "entrance"}
 
 	// prefer the first 'main' entrance we find (should usually only be one).
 	for _, entrance := range entrances {
-		if val, ok := entrance["entrance"]; ok && val == "2" {
-			centroid["lat"] = entrance["lat"]
-			centroid["lon"] = entrance["lon"]
+		if entrance.Entrance == 2 {
+			centroid.Lat, centroid.Lon = entrance.Lat, entrance.Lon
 			return centroid
 		}
 	}
 
 	// else prefer the first wheelchair accessible entrance we find
 	for _, entrance := range entrances {
-		if val, ok := entrance["wheelchair"]; ok && val != "0" {
-			centroid["lat"] = entrance["lat"]
-			centroid["lon"] = entrance["lon"]
+		if entrance.Wheelchair != 0 {
+			centroid.Lat, centroid.Lon = entrance.Lat, entrance.Lon
 			return centroid
 		}
 	}
 
 	// otherwise just take the first entrance in the list
-	centroid["lat"] = entrances[0]["lat"]
-	centroid["lon"] = entrances[0]["lon"]
+	centroid.Lat, centroid.Lon = entrances[0].Lat, entrances[0].Lon
 	return centroid
 }
 
 // compute the centroid of a way and its bbox
-func computeCentroidAndBounds(latlons []map[string]string) (map[string]string, *geo.Bound) {
+func computeCentroidAndBounds(latlons []latLon) (jsonCentroid, *geo.Bound) {
 
 	// check to see if there is a tagged entrance we can use.
-	var entrances []map[string]string
+	var entrances []latLon
 	for _, latlon := range latlons {
-		if _, ok := latlon["entrance"]; ok {
+		if latlon.Entrance > 0 {
 			entrances = append(entrances, latlon)
 		}
 	}
 
-	// convert lat/lon map to geo.PointSet
-	points := geo.NewPointSet()
+	// convert latlons to geo.PointSet
+	points := make(geo.PointSet, 0, len(latlons))
 	for _, each := range latlons {
-		var lon, _ = strconv.ParseFloat(each["lon"], 64)
-		var lat, _ = strconv.ParseFloat(each["lat"], 64)
-		points.Push(geo.NewPoint(lon, lat))
+		points.Push(geo.NewPoint(each.Lon, each.Lat))
 	}
 
 	// use the mapped entrance location where available
@@ -719,15 +1337,10 @@ func computeCentroidAndBounds(latlons []map[string]string) (map[string]string, *
 	// compute the centroid using one of two different algorithms
 	var compute *geo.Point
 	if isClosed {
-		compute = GetPolygonCentroid(points)
+		compute = GetPolygonCentroid(&points)
 	} else {
-		compute = GetLineCentroid(points)
+		compute = GetLineCentroid(&points)
 	}
 
-	// return point as lat/lon map
-	var centroid = make(map[string]string)
-	centroid["lat"] = strconv.FormatFloat(compute.Lat(), 'f', 7, 64)
-	centroid["lon"] = strconv.FormatFloat(compute.Lng(), 'f', 7, 64)
-
-	return centroid, points.Bound()
+	return jsonCentroid{Lat: compute.Lat(), Lon: compute.Lng()}, points.Bound()
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrimTags(t *testing.T) {
+	trimmed, conflicts := trimTagsWithConflicts(map[string]string{"amenity": " cafe", " name ": "A "})
+	assert.Equal(t, map[string]string{"amenity": "cafe", "name": "A"}, trimmed)
+	assert.Empty(t, conflicts)
+}
+
+func TestTrimTagsConflicts(t *testing.T) {
+
+	// a key which needed no trimming wins
+	for i := 0; i < 10; i++ {
+		trimmed, conflicts := trimTagsWithConflicts(map[string]string{" name": "A", "name": "B", "name ": "C"})
+		assert.Equal(t, map[string]string{"name": "B"}, trimmed)
+		assert.Equal(t, []string{" name", "name", "name "}, conflicts)
+	}
+
+	// otherwise the key which sorts first wins
+	for i := 0; i < 10; i++ {
+		trimmed, conflicts := trimTagsWithConflicts(map[string]string{"name ": "C", " name": "A", "ref": "1"})
+		assert.Equal(t, map[string]string{"name": "A", "ref": "1"}, trimmed)
+		assert.Equal(t, []string{" name", "name "}, conflicts)
+	}
+}
+
+func TestMatchElement(t *testing.T) {
+	tags := map[string]string{" amenity": "cafe "}
+	cafes := &layer{Name: "cafes", Tags: parseTagConditions("amenity~cafe"), Types: map[string]bool{"node": true}}
+	venues := &layer{Name: "venues", Tags: parseTagConditions("amenity"), Types: map[string]bool{"node": true}}
+	config := settings{Profiles: []*profile{{Layers: []*layer{cafes, venues}}}}
+	assert.Empty(t, matchElement("node", tags, config))
+
+	config.Normalise = true
+	assert.Equal(t, []*layer{cafes}, matchElement("node", tags, config))
+	assert.Empty(t, matchElement("way", tags, config))
+	assert.Empty(t, matchElement("node", map[string]string{}, config))
+
+	// an element is matched once by each profile
+	config.Profiles = append(config.Profiles, &profile{Name: "other", Layers: []*layer{venues}})
+	assert.Equal(t, []*layer{cafes, venues}, matchElement("node", tags, config))
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/go.geo"
+)
+
+// pelias layers assigned when the layer of a record is not mapped
+const (
+	peliasVenue   = "venue"
+	peliasAddress = "address"
+)
+
+// pelias address parts and the tags they are read from
+var peliasAddressParts = []struct{ part, tag string }{
+	{"number", "addr:housenumber"},
+	{"street", "addr:street"},
+	{"unit", "addr:unit"},
+	{"zip", "addr:postcode"},
+}
+
+// 'name:xx' tags with a language code suffix are localised names
+var peliasLanguageName = regexp.MustCompile(`^name:([a-z]{2,3})$`)
+
+// peliasConfig - how records are mapped to pelias documents
+type peliasConfig struct {
+	Source     string            `json:"source"`     // default 'openstreetmap'
+	Layers     map[string]string `json:"layers"`     // pbf2json layer name to pelias layer
+	Categories []peliasCategory  `json:"categories"` // in the order categories are listed
+}
+
+// peliasCategory - categories assigned to records matching any of the tag conditions
+type peliasCategory struct {
+	Tags       []string `json:"tags"` // groups of tag conditions, as -tags
+	Category   []string `json:"category"`
+	conditions map[string][]string
+}
+
+// loadPeliasConfig - read a JSON pelias config, the defaults are used if no path is specified
+func loadPeliasConfig(path string) (*peliasConfig, error) {
+	config := &peliasConfig{}
+	if len(path) > 0 {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("invalid pelias config %s: %v", path, err)
+		}
+	}
+	if config.Source == "" {
+		config.Source = "openstreetmap"
+	}
+	for i, c := range config.Categories {
+		if len(c.Tags) == 0 || len(c.Category) == 0 {
+			return nil, fmt.Errorf("invalid pelias config, category %d requires 'tags' and 'category'", i+1)
+		}
+		config.Categories[i].conditions = parseTagConditions(strings.Join(c.Tags, ","))
+	}
+	return config, nil
+}
+
+// peliasEncoder - encodes records as pelias documents, records which
+// have neither a name nor a full address are not encoded.
+type peliasEncoder struct {
+	config *peliasConfig
+	json   *recordEncoder // encodes strings and coordinates
+	tags   map[string]string
+}
+
+// newPeliasEncoder - constructor, coordinates are rounded to precision decimal places
+func newPeliasEncoder(config *peliasConfig, precision int) *peliasEncoder {
+	return &peliasEncoder{config: config, json: newRecordEncoder(schemaV2, precision), tags: make(map[string]string)}
+}
+
+func (e *peliasEncoder) Ext() string {
+	return ".json"
+}
+
+func (e *peliasEncoder) Header() []byte {
+	return nil
+}
+
+// Node - encode a node document
+func (e *peliasEncoder) Node(r *jsonNode) []byte {
+	return e.document(r.Type, r.ID, r.Layer, r.Tags, r.Lat, r.Lon, nil)
+}
+
+// Way - encode a way document
+func (e *peliasEncoder) Way(r *jsonWay) []byte {
+	return e.document(r.Type, r.ID, r.Layer, r.Tags, r.Centroid.Lat, r.Centroid.Lon, r.Bounds)
+}
+
+// Relation - encode a relation document
+func (e *peliasEncoder) Relation(r *jsonRelation) []byte {
+	return e.document(r.Type, r.ID, r.Layer, r.Tags, r.Centroid.Lat, r.Centroid.Lon, r.Bounds)
+}
+
+func (e *peliasEncoder) document(kind string, id int64, layer string, tags interface{}, lat float64, lon float64, bounds *geo.Bound) []byte {
+	e.loadTags(tags)
+
+	// choose the pelias layer, an address is named after its number and street
+	name := e.tags["name"]
+	address := e.tags["addr:housenumber"] != "" && e.tags["addr:street"] != ""
+	peliasLayer, mapped := e.config.Layers[layer]
+	switch {
+	case mapped:
+	case name != "":
+		peliasLayer = peliasVenue
+	case address:
+		peliasLayer = peliasAddress
+	default:
+		return nil
+	}
+	if name == "" && peliasLayer == peliasAddress && address {
+		name = e.tags["addr:housenumber"] + " " + e.tags["addr:street"]
+	}
+	if name == "" {
+		return nil
+	}
+
+	b := e.json.buf[:0]
+	b = append(b, `{"source":`...)
+	b = appendString(b, e.config.Source)
+	b = append(b, `,"layer":`...)
+	b = appendString(b, peliasLayer)
+	sourceID := kind + "/" + strconv.FormatInt(id, 10)
+	b = append(b, `,"source_id":`...)
+	b = appendString(b, sourceID)
+	b = append(b, `,"gid":`...)
+	b = appendString(b, e.config.Source+":"+peliasLayer+":"+sourceID)
+
+	b = append(b, `,"name":{"default":`...)
+	b = appendString(b, name)
+	b = e.appendLocalisedNames(b)
+	b = append(b, '}')
+
+	b = e.appendAddressParts(b)
+
+	b = append(b, `,"center_point":{"lat":`...)
+	b = e.json.appendCoord(b, lat)
+	b = append(b, `,"lon":`...)
+	b = e.json.appendCoord(b, lon)
+	b = append(b, '}')
+
+	// pelias stores the bounding box as a JSON encoded string
+	if bounds != nil {
+		bbox := e.json.appendCoord([]byte(`{"min_lat":`), bounds.South())
+		bbox = append(bbox, `,"max_lat":`...)
+		bbox = e.json.appendCoord(bbox, bounds.North())
+		bbox = append(bbox, `,"min_lon":`...)
+		bbox = e.json.appendCoord(bbox, bounds.West())
+		bbox = append(bbox, `,"max_lon":`...)
+		bbox = e.json.appendCoord(bbox, bounds.East())
+		bbox = append(bbox, '}')
+		b = append(b, `,"bounding_box":`...)
+		b = appendString(b, string(bbox))
+	}
+
+	b = e.appendCategories(b)
+	b = append(b, '}')
+	e.json.buf = b
+	return b
+}
+
+// load the tags of a record in to the reusable map, split values are
+// represented by their first value
+func (e *peliasEncoder) loadTags(tags interface{}) {
+	for k := range e.tags {
+		delete(e.tags, k)
+	}
+	switch tags := tags.(type) {
+	case map[string]string:
+		for k, v := range tags {
+			e.tags[k] = v
+		}
+	case map[string]interface{}:
+		for k, v := range tags {
+			switch v := v.(type) {
+			case string:
+				e.tags[k] = v
+			case []string:
+				if len(v) > 0 {
+					e.tags[k] = v[0]
+				}
+			}
+		}
+	}
+}
+
+// append 'name:xx' tags keyed by language, in key order
+func (e *peliasEncoder) appendLocalisedNames(b []byte) []byte {
+	keys := e.json.keys[:0]
+	for k := range e.tags {
+		if peliasLanguageName.MatchString(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	e.json.keys = keys
+	for _, k := range keys {
+		b = append(b, ',')
+		b = appendString(b, strings.TrimPrefix(k, "name:"))
+		b = append(b, ':')
+		b = appendString(b, e.tags[k])
+	}
+	return b
+}
+
+// append the address parts found in 'addr:*' tags, if any
+func (e *peliasEncoder) appendAddressParts(b []byte) []byte {
+	first := true
+	for _, p := range peliasAddressParts {
+		val := e.tags[p.tag]
+		if val == "" {
+			continue
+		}
+		if first {
+			b = append(b, `,"address_parts":{`...)
+			first = false
+		} else {
+			b = append(b, ',')
+		}
+		b = appendString(b, p.part)
+		b = append(b, ':')
+		b = appendString(b, val)
+	}
+	if !first {
+		b = append(b, '}')
+	}
+	return b
+}
+
+// append the categories of every matching category rule, without duplicates
+func (e *peliasEncoder) appendCategories(b []byte) []byte {
+	var categories []string
+	for _, c := range e.config.Categories {
+		if !containsValidTags(e.tags, c.conditions) {
+			continue
+		}
+		for _, category := range c.Category {
+			if !containsString(categories, category) {
+				categories = append(categories, category)
+			}
+		}
+	}
+	if len(categories) == 0 {
+		return b
+	}
+	b = append(b, `,"category":[`...)
+	for i, category := range categories {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendString(b, category)
+	}
+	return append(b, ']')
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/go.geo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeliasVenue(t *testing.T) {
+	config, _ := loadPeliasConfig("")
+	e := newPeliasEncoder(config, 7)
+	node := jsonNode{ID: 1, Type: "node", Lat: 1.5, Lon: -2.25, Tags: map[string]string{
+		"name": "Cafe", "name:fr": "Café", "name:de": "Kaffee", "name:source": "survey",
+		"addr:housenumber": "10", "addr:street": "Main St", "addr:postcode": "1234",
+	}}
+	assert.Equal(t, `{"source":"openstreetmap","layer":"venue","source_id":"node/1","gid":"openstreetmap:venue:node/1",`+
+		`"name":{"default":"Cafe","de":"Kaffee","fr":"Café"},"address_parts":{"number":"10","street":"Main St","zip":"1234"},`+
+		`"center_point":{"lat":1.5,"lon":-2.25}}`, string(e.Node(&node)))
+}
+
+func TestPeliasAddress(t *testing.T) {
+	config, _ := loadPeliasConfig("")
+	e := newPeliasEncoder(config, 7)
+	way := jsonWay{
+		ID:       10,
+		Type:     "way",
+		Tags:     map[string]interface{}{"addr:housenumber": []string{"10", "12"}, "addr:street": "Main St"},
+		Centroid: jsonCentroid{Lat: 2, Lon: 2},
+		Bounds:   geo.NewBound(1, 2, 3, 4),
+	}
+	data := e.Way(&way)
+
+	var doc map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &doc))
+	assert.Equal(t, "address", doc["layer"])
+	assert.Equal(t, map[string]interface{}{"default": "10 Main St"}, doc["name"])
+	assert.Equal(t, `{"min_lat":3,"max_lat":4,"min_lon":1,"max_lon":2}`, doc["bounding_box"])
+}
+
+func TestPeliasUnnamed(t *testing.T) {
+	config, _ := loadPeliasConfig("")
+	e := newPeliasEncoder(config, 7)
+	node := jsonNode{ID: 1, Type: "node", Tags: map[string]string{"amenity": "bench"}}
+	assert.Nil(t, e.Node(&node))
+
+	// a mapped layer still requires a name
+	config.Layers = map[string]string{"venues": "venue"}
+	node.Layer = "venues"
+	assert.Nil(t, e.Node(&node))
+}
+
+func TestPeliasConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pelias.json")
+	ioutil.WriteFile(path, []byte(`{
+		"source": "osm",
+		"layers": { "shops": "venue", "houses": "address" },
+		"categories": [
+			{ "tags": [ "amenity~cafe", "amenity~restaurant" ], "category": [ "food", "retail" ] },
+			{ "tags": [ "shop" ], "category": [ "retail" ] }
+		]
+	}`), 0644)
+	config, err := loadPeliasConfig(path)
+	assert.Nil(t, err)
+	e := newPeliasEncoder(config, 7)
+
+	node := jsonNode{ID: 1, Type: "node", Layer: "houses", Tags: map[string]string{
+		"name": "Corner Cafe", "amenity": "cafe", "shop": "coffee", "addr:housenumber": "1", "addr:street": "Main St",
+	}}
+	var doc map[string]interface{}
+	assert.Nil(t, json.Unmarshal(e.Node(&node), &doc))
+	assert.Equal(t, "address", doc["layer"])
+	assert.Equal(t, "osm:address:node/1", doc["gid"])
+	assert.Equal(t, []interface{}{"food", "retail"}, doc["category"])
+
+	// invalid category
+	ioutil.WriteFile(path, []byte(`{ "categories": [ { "tags": [ "shop" ] } ] }`), 0644)
+	_, err = loadPeliasConfig(path)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/paulmach/go.geo"
+)
+
+// types of the tags column of pgcopy output
+const (
+	pgTagsJSONB  = "jsonb"
+	pgTagsHstore = "hstore"
+)
+
+// the srid of pgcopy geometries, WGS 84 longitude/latitude
+const pgSRID = 4326
+
+// the null value of the COPY text format
+var pgNull = []byte(`\N`)
+
+// pgCreateTable - the DDL of a table which pgcopy output can be copied in
+// to without any transformation, the columns are in the order they are written
+func pgCreateTable(table string, tags string) string {
+	extensions := "CREATE EXTENSION IF NOT EXISTS postgis;\n"
+	if tags == pgTagsHstore {
+		extensions += "CREATE EXTENSION IF NOT EXISTS hstore;\n"
+	}
+	return fmt.Sprintf(`%s
+-- load with: \copy %[2]s FROM 'records.copy'
+CREATE TABLE %[2]s (
+	id bigint NOT NULL,
+	type text NOT NULL,
+	layer text,
+	tags %[3]s NOT NULL,
+	centroid geometry(Point, %[4]d) NOT NULL,
+	bounds geometry(Polygon, %[4]d),
+	geom geometry(Geometry, %[4]d),
+	partial boolean,
+	version integer,
+	timestamp timestamptz,
+	changeset bigint,
+	uid integer,
+	"user" text,
+	visible boolean
+);
+`, extensions, table, tags, pgSRID)
+}
+
+// pgCopyEncoder - encodes records as rows of the PostgreSQL COPY text format,
+// tab-separated with backslash escapes and \N for null values. Geometries are
+// hex encoded EWKB, which PostGIS accepts as geometry input.
+type pgCopyEncoder struct {
+	hstore bool
+	json   *recordEncoder // encodes jsonb tags
+	value  []byte
+	geom   []byte
+}
+
+// newPGCopyEncoder - constructor, tags are written as a jsonb or hstore literal
+func newPGCopyEncoder(tags string) *pgCopyEncoder {
+	return &pgCopyEncoder{hstore: tags == pgTagsHstore, json: newRecordEncoder(schemaV2, defaultPrecision)}
+}
+
+func (e *pgCopyEncoder) Ext() string {
+	return ".copy"
+}
+
+// Header - COPY text files don't have a header row
+func (e *pgCopyEncoder) Header() []byte {
+	return nil
+}
+
+// Node - encode a node row, the centroid and geometry are the node location
+func (e *pgCopyEncoder) Node(r *jsonNode) []byte {
+	b := e.appendElement(e.json.buf[:0], r.ID, r.Type, r.Layer, r.Tags)
+	e.geom = appendWKBPoint(e.geom[:0], r.Lon, r.Lat, pgSRID)
+	b = append(b, '\t')
+	b = appendHex(b, e.geom)
+	b = append(b, '\t')
+	b = append(b, pgNull...)
+	b = append(b, '\t')
+	b = appendHex(b, e.geom)
+	b = append(b, '\t')
+	b = append(b, pgNull...)
+	b = e.appendMetadata(b, r.jsonMetadata)
+	e.json.buf = b
+	return b
+}
+
+// Way - encode a way row, the geometry is a line or polygon when the node
+// lat/lons are printed
+func (e *pgCopyEncoder) Way(r *jsonWay) []byte {
+	b := e.appendElement(e.json.buf[:0], r.ID, r.Type, r.Layer, r.Tags)
+	b = e.appendCentroidAndBounds(b, r.Centroid, r.Bounds)
+	b = append(b, '\t')
+	if len(r.Nodes) > 1 {
+		e.geom = appendWKBWay(e.geom[:0], r.Nodes, pgSRID)
+		b = appendHex(b, e.geom)
+	} else {
+		b = append(b, pgNull...)
+	}
+	b = append(b, '\t')
+	b = appendPGBool(b, r.Partial)
+	b = e.appendMetadata(b, r.jsonMetadata)
+	e.json.buf = b
+	return b
+}
+
+// Relation - encode a relation row, the geometry is assembled from the
+// member ways when the node lat/lons of ways are printed
+func (e *pgCopyEncoder) Relation(r *jsonRelation) []byte {
+	b := e.appendElement(e.json.buf[:0], r.ID, r.Type, r.Layer, r.Tags)
+	b = e.appendCentroidAndBounds(b, r.Centroid, r.Bounds)
+	b = append(b, '\t')
+	if r.Geometry != nil {
+		e.geom = appendWKBShape(e.geom[:0], r.Geometry, pgSRID)
+		b = appendHex(b, e.geom)
+	} else {
+		b = append(b, pgNull...)
+	}
+	b = append(b, '\t')
+	b = appendPGBool(b, r.Partial)
+	b = e.appendMetadata(b, r.jsonMetadata)
+	e.json.buf = b
+	return b
+}
+
+// append the id, type, layer and tags columns
+func (e *pgCopyEncoder) appendElement(b []byte, id int64, kind string, layer string, tags interface{}) []byte {
+	b = strconv.AppendInt(b, id, 10)
+	b = append(b, '\t')
+	b = append(b, kind...)
+	b = append(b, '\t')
+	if layer == "" {
+		b = append(b, pgNull...)
+	} else {
+		b = appendTSVValue(b, []byte(layer))
+	}
+	b = append(b, '\t')
+	if e.hstore {
+		e.value = e.appendHstore(e.value[:0], tags)
+	} else {
+		e.value = e.json.appendTags(e.value[:0], tags)
+	}
+	return appendTSVValue(b, e.value)
+}
+
+func (e *pgCopyEncoder) appendCentroidAndBounds(b []byte, centroid jsonCentroid, bounds *geo.Bound) []byte {
+	e.geom = appendWKBPoint(e.geom[:0], centroid.Lon, centroid.Lat, pgSRID)
+	b = append(b, '\t')
+	b = appendHex(b, e.geom)
+	b = append(b, '\t')
+	if bounds == nil {
+		return append(b, pgNull...)
+	}
+	e.geom = appendWKBBounds(e.geom[:0], bounds, pgSRID)
+	return appendHex(b, e.geom)
+}
+
+// append the metadata columns, null for fields which aren't printed
+func (e *pgCopyEncoder) appendMetadata(b []byte, meta *jsonMetadata) []byte {
+	if meta == nil {
+		meta = &jsonMetadata{}
+	}
+	b = append(b, '\t')
+	if meta.Version != nil {
+		b = strconv.AppendInt(b, int64(*meta.Version), 10)
+	} else {
+		b = append(b, pgNull...)
+	}
+	b = append(b, '\t')
+	if meta.Timestamp != nil {
+		b = meta.Timestamp.AppendFormat(b, time.RFC3339Nano)
+	} else {
+		b = append(b, pgNull...)
+	}
+	b = append(b, '\t')
+	if meta.Changeset != nil {
+		b = strconv.AppendInt(b, *meta.Changeset, 10)
+	} else {
+		b = append(b, pgNull...)
+	}
+	b = append(b, '\t')
+	if meta.UID != nil {
+		b = strconv.AppendInt(b, int64(*meta.UID), 10)
+	} else {
+		b = append(b, pgNull...)
+	}
+	b = append(b, '\t')
+	if meta.User != nil {
+		b = appendTSVValue(b, []byte(*meta.User))
+	} else {
+		b = append(b, pgNull...)
+	}
+	b = append(b, '\t')
+	if meta.Visible != nil {
+		return appendPGBool(b, *meta.Visible)
+	}
+	return append(b, pgNull...)
+}
+
+// append an hstore literal of the tags in key order, split values are joined with ';'
+func (e *pgCopyEncoder) appendHstore(b []byte, tags interface{}) []byte {
+	keys := e.json.keys[:0]
+	switch tags := tags.(type) {
+	case map[string]string:
+		for k := range tags {
+			keys = append(keys, k)
+		}
+	case map[string]interface{}:
+		for k := range tags {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	e.json.keys = keys
+
+	var value []byte
+	for i, k := range keys {
+		if i > 0 {
+			b = append(b, ", "...)
+		}
+		b = appendHstoreString(b, k)
+		b = append(b, "=>"...)
+		value = appendTagValue(value[:0], tags, k)
+		b = appendHstoreString(b, string(value))
+	}
+	return b
+}
+
+// append a double quoted hstore key or value
+func appendHstoreString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+	return append(b, '"')
+}
+
+func appendPGBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 't')
+	}
+	return append(b, 'f')
+}
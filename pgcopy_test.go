@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paulmach/go.geo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPGCreateTable(t *testing.T) {
+	ddl := pgCreateTable("osm_records", pgTagsJSONB)
+	assert.Contains(t, ddl, "CREATE TABLE osm_records (")
+	assert.Contains(t, ddl, "tags jsonb NOT NULL")
+	assert.NotContains(t, ddl, "hstore")
+
+	ddl = pgCreateTable("osm_records", pgTagsHstore)
+	assert.Contains(t, ddl, "CREATE EXTENSION IF NOT EXISTS hstore;")
+	assert.Contains(t, ddl, "tags hstore NOT NULL")
+
+	// a column for every value in a row
+	columns := strings.Count(ddl[strings.Index(ddl, "("):], ",\n") + 1
+	e := newPGCopyEncoder(pgTagsJSONB)
+	row := e.Node(&jsonNode{ID: 1, Type: "node", Tags: map[string]string{}})
+	assert.Len(t, strings.Split(string(row), "\t"), columns)
+}
+
+func TestWKBBounds(t *testing.T) {
+	b := appendWKBBounds(nil, geo.NewBound(1, 2, 3, 4), pgSRID)
+	assert.Equal(t, "0103000020e6100000", hex.EncodeToString(b[:9])) // EWKB polygon with an srid
+	assert.Equal(t, uint32(1), binary.LittleEndian.Uint32(b[9:]))
+	assert.Equal(t, uint32(5), binary.LittleEndian.Uint32(b[13:]))
+
+	var coords []float64
+	for i := 17; i < len(b); i += 8 {
+		coords = append(coords, math.Float64frombits(binary.LittleEndian.Uint64(b[i:])))
+	}
+	assert.Equal(t, []float64{1, 3, 2, 3, 2, 4, 1, 4, 1, 3}, coords)
+}
+
+func TestPGCopyNode(t *testing.T) {
+	e := newPGCopyEncoder(pgTagsJSONB)
+	user, ts := `back\slash`, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	node := jsonNode{ID: 1, Type: "node", Layer: "venues", Lat: 1.5, Lon: -2.25, Tags: map[string]string{"name": "tab\there"}, jsonMetadata: &jsonMetadata{Timestamp: &ts, User: &user}}
+	point := hex.EncodeToString(appendWKBPoint(nil, -2.25, 1.5, pgSRID))
+	assert.Equal(t, "1\tnode\tvenues\t"+`{"name":"tab\\there"}`+"\t"+point+"\t\\N\t"+point+"\t\\N\t\\N\t2020-01-02T03:04:05Z\t\\N\t\\N\t"+`back\\slash`+"\t\\N", string(e.Node(&node)))
+}
+
+func TestPGCopyWay(t *testing.T) {
+	e := newPGCopyEncoder(pgTagsHstore)
+	nodes := []latLon{{Lat: 1, Lon: 1}, {Lat: 2, Lon: 2}}
+	way := jsonWay{ID: 10, Type: "way", Tags: map[string]string{"name": `"quoted"`, "building": "yes"}, Centroid: jsonCentroid{Lat: 1.5, Lon: 1.5}, Bounds: geo.NewBound(1, 2, 1, 2), Nodes: nodes, Partial: true}
+	fields := strings.Split(string(e.Way(&way)), "\t")
+	assert.Equal(t, []string{"10", "way", `\N`, `"building"=>"yes", "name"=>"\\"quoted\\""`}, fields[:4])
+	assert.Equal(t, hex.EncodeToString(appendWKBPoint(nil, 1.5, 1.5, pgSRID)), fields[4])
+	assert.Equal(t, hex.EncodeToString(appendWKBBounds(nil, way.Bounds, pgSRID)), fields[5])
+	assert.Equal(t, hex.EncodeToString(appendWKBWay(nil, nodes, pgSRID)), fields[6])
+	assert.Equal(t, "t", fields[7])
+
+	// without node lat/lons there is no geometry
+	way.Nodes = nil
+	fields = strings.Split(string(e.Way(&way)), "\t")
+	assert.Equal(t, `\N`, fields[6])
+}
+
+func TestPGCopyRelation(t *testing.T) {
+	e := newPGCopyEncoder(pgTagsHstore)
+	relation := jsonRelation{ID: 20, Type: "relation", Tags: map[string]interface{}{"name": []string{"a", "b"}}, Centroid: jsonCentroid{Lat: 5, Lon: 5}, Bounds: geo.NewBound(1, 2, 3, 4)}
+	fields := strings.Split(string(e.Relation(&relation)), "\t")
+	assert.Len(t, fields, 14)
+	assert.Equal(t, `"name"=>"a;b"`, fields[3])
+	assert.Equal(t, []string{`\N`, "f"}, fields[6:8])
+
+	// the geometry assembled from the member ways, only the outer geometry has an srid
+	relation.Geometry = &shape{polygons: [][][]latLon{{square(0, 0, 1)}, {square(2, 2, 1)}}}
+	fields = strings.Split(string(e.Relation(&relation)), "\t")
+	geom, err := hex.DecodeString(fields[6])
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(wkbMultiPolygon|ewkbSRID), binary.LittleEndian.Uint32(geom[1:]))
+	assert.Equal(t, uint32(pgSRID), binary.LittleEndian.Uint32(geom[5:]))
+	assert.Equal(t, uint32(2), binary.LittleEndian.Uint32(geom[9:]))
+	assert.Equal(t, appendWKBWay(nil, square(0, 0, 1), 0), geom[13:13+(len(geom)-13)/2])
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// progress - periodically reports the progress of each decoding pass on stderr.
+// note: a nil *progress is valid and reports nothing.
+type progress struct {
+	interval time.Duration
+	pass     string
+	started  time.Time
+	reported time.Time
+	total    int64 // bytes to read during this pass
+	read     int64 // bytes read so far, updated by the decoder goroutine
+	elements int64
+	cached   int64
+	emitted  int64
+}
+
+// newProgress - constructor, returns nil when reporting is disabled
+func newProgress(interval time.Duration) *progress {
+	if interval <= 0 {
+		return nil
+	}
+	return &progress{interval: interval}
+}
+
+// Start - reset counters at the beginning of a pass over files
+func (p *progress) Start(pass string, files []*os.File) {
+	if p == nil {
+		return
+	}
+	*p = progress{interval: p.interval, pass: pass, started: time.Now()}
+	p.reported = p.started
+	for _, file := range files {
+		if info, err := file.Stat(); err == nil {
+			p.total += info.Size()
+		}
+	}
+}
+
+// Finish - report the totals at the end of a pass
+func (p *progress) Finish() {
+	if p == nil {
+		return
+	}
+	elapsed := time.Since(p.started)
+	log.Printf("[info] %s: finished in %s, %d elements, %d cache writes, %d records\n",
+		p.pass, elapsed.Round(time.Second), p.elements, p.cached, p.emitted)
+}
+
+// Reader - wrap a reader to record the bytes read during this pass
+func (p *progress) Reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &countingReader{r: r, n: &p.read}
+}
+
+// Element - record an element decoded, reporting progress when due
+func (p *progress) Element() {
+	if p == nil {
+		return
+	}
+	p.elements++
+
+	// avoid checking the clock for every element
+	if p.elements%10000 == 0 && time.Since(p.reported) >= p.interval {
+		p.report()
+	}
+}
+
+// Cached - record an element queued for writing to leveldb
+func (p *progress) Cached() {
+	if p != nil {
+		p.cached++
+	}
+}
+
+// Emitted - record a record printed
+func (p *progress) Emitted() {
+	if p != nil {
+		p.emitted++
+	}
+}
+
+func (p *progress) report() {
+	p.reported = time.Now()
+	elapsed := p.reported.Sub(p.started)
+	read := atomic.LoadInt64(&p.read)
+	rate := float64(p.elements) / elapsed.Seconds()
+
+	// size and estimated time remaining are only known for regular files
+	position := formatBytes(read)
+	eta := "unknown"
+	if p.total > 0 && read > 0 {
+		position = fmt.Sprintf("%s / %s (%.1f%%)", formatBytes(read), formatBytes(p.total), 100*float64(read)/float64(p.total))
+		remaining := time.Duration(float64(elapsed) * float64(p.total-read) / float64(read))
+		eta = remaining.Round(time.Second).String()
+	}
+
+	log.Printf("[info] %s: %s, %d elements (%.0f/s), %d cache writes, %d records, eta %s\n",
+		p.pass, position, p.elements, rate, p.cached, p.emitted, eta)
+}
+
+// countingReader - keep a running total of the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// render a byte count in human readable form, eg. '1.5 GiB'
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
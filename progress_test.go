@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "0 B", formatBytes(0))
+	assert.Equal(t, "1023 B", formatBytes(1023))
+	assert.Equal(t, "1.0 KiB", formatBytes(1024))
+	assert.Equal(t, "1.5 MiB", formatBytes(1024*1024*3/2))
+	assert.Equal(t, "60.0 GiB", formatBytes(60*1024*1024*1024))
+}
+
+func TestProgressDisabled(t *testing.T) {
+
+	// a zero interval disables reporting, all methods are no-ops
+	var p = newProgress(0)
+	assert.Nil(t, p)
+
+	var r = strings.NewReader("data")
+	assert.Equal(t, r, p.Reader(r))
+	p.Start("index", nil)
+	p.Element()
+	p.Cached()
+	p.Emitted()
+	p.Finish()
+}
+
+func TestProgressReport(t *testing.T) {
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var file, _ = ioutil.TempFile(t.TempDir(), "input.pbf")
+	defer file.Close()
+	file.Write(make([]byte, 2048))
+
+	var p = newProgress(time.Nanosecond)
+	p.Start("print", []*os.File{file})
+	assert.Equal(t, int64(2048), p.total)
+
+	// read half of the file
+	ioutil.ReadAll(p.Reader(strings.NewReader(string(make([]byte, 1024)))))
+	p.Cached()
+	p.Emitted()
+	for i := 0; i < 10000; i++ {
+		p.Element()
+	}
+
+	var line = buf.String()
+	assert.Contains(t, line, "[info] print: 1.0 KiB / 2.0 KiB (50.0%), 10000 elements")
+	assert.Contains(t, line, "1 cache writes, 1 records, eta")
+
+	// counters are reset for each pass
+	p.Start("index", nil)
+	assert.Equal(t, int64(0), p.elements)
+	assert.Equal(t, int64(0), p.read)
+}
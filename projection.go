@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// tagProjection - selects which tags are printed, independently of the
+// tags used to match elements.
+// note: a nil *tagProjection is valid and keeps every tag.
+type tagProjection struct {
+	keep []string // glob patterns, when set only matching keys are kept
+	drop []string // glob patterns, matching keys are removed
+}
+
+// newTagProjection - parse comma-separated lists of glob patterns,
+// returns nil if no patterns are specified
+func newTagProjection(keep, drop string) (*tagProjection, error) {
+	p := &tagProjection{}
+	var err error
+	if p.keep, err = parseTagPatterns(keep); err != nil {
+		return nil, err
+	}
+	if p.drop, err = parseTagPatterns(drop); err != nil {
+		return nil, err
+	}
+	if len(p.keep) == 0 && len(p.drop) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// parse and validate a comma-separated list of glob patterns
+func parseTagPatterns(list string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(list, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) < 1 {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid tag pattern '%s': %v", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// Apply - select the tags which should be printed
+// note: the tags may be shared between profiles, so a copy is returned
+func (p *tagProjection) Apply(tags map[string]string) map[string]string {
+	if p == nil {
+		return tags
+	}
+	projected := make(map[string]string, len(tags))
+	for key, val := range tags {
+		if (len(p.keep) > 0 && !matchTagPatterns(p.keep, key)) || matchTagPatterns(p.drop, key) {
+			continue
+		}
+		projected[key] = val
+	}
+	return projected
+}
+
+// check if a key matches any of the patterns
+func matchTagPatterns(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
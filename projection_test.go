@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTagProjection(t *testing.T) {
+	p, err := newTagProjection("", "")
+	assert.Nil(t, err)
+	assert.Nil(t, p)
+
+	p, err = newTagProjection("name, name:*,", "tiger:*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"name", "name:*"}, p.keep)
+	assert.Equal(t, []string{"tiger:*"}, p.drop)
+
+	_, err = newTagProjection("name:[", "")
+	assert.NotNil(t, err)
+}
+
+func TestTagProjectionApply(t *testing.T) {
+	tags := func() map[string]string {
+		return map[string]string{
+			"name":             "Oriental Bay Fountain",
+			"name:en":          "Oriental Bay Fountain",
+			"amenity":          "fountain",
+			"addr:street":      "Oriental Parade",
+			"created_by":       "Potlatch 0.5d",
+			"tiger:county":     "Wellington",
+			"source":           "knowledge",
+			"addr:housenumber": "1",
+		}
+	}
+
+	// nil projection keeps everything
+	var p *tagProjection
+	assert.Len(t, p.Apply(tags()), 8)
+
+	// keep only
+	p, _ = newTagProjection("name,name:*,amenity", "")
+	assert.Equal(t, map[string]string{
+		"name":    "Oriental Bay Fountain",
+		"name:en": "Oriental Bay Fountain",
+		"amenity": "fountain",
+	}, p.Apply(tags()))
+
+	// drop only
+	p, _ = newTagProjection("", "created_by,source,tiger:*")
+	assert.Equal(t, map[string]string{
+		"name":             "Oriental Bay Fountain",
+		"name:en":          "Oriental Bay Fountain",
+		"amenity":          "fountain",
+		"addr:street":      "Oriental Parade",
+		"addr:housenumber": "1",
+	}, p.Apply(tags()))
+
+	// drop takes precedence over keep
+	p, _ = newTagProjection("addr:*", "addr:housenumber")
+	assert.Equal(t, map[string]string{
+		"addr:street": "Oriental Parade",
+	}, p.Apply(tags()))
+}
@@ -0,0 +1,127 @@
+package main
+
+import "math"
+
+// the length of a degree of latitude, on a sphere with the mean radius of the earth
+const metresPerDegree = 2 * math.Pi * 6371008.8 / 360
+
+// simplifyLatLons - Douglas-Peucker simplification of the nodes of a way or
+// a ring, dropping nodes closer than tolerance metres to the line between the
+// nodes which are kept. The ends of a line and entrance nodes are always kept
+// and rings keep at least 4 nodes, so they remain polygons.
+// note: distances are measured on a plane scaled for the mean latitude, which
+// is accurate enough for the lengths of typical ways.
+func simplifyLatLons(latlons []latLon, tolerance float64) []latLon {
+	if len(latlons) < 3 || tolerance <= 0 {
+		return latlons
+	}
+
+	// project on to a plane in metres
+	var lat float64
+	for _, latlon := range latlons {
+		lat += latlon.Lat
+	}
+	scale := math.Cos(lat / float64(len(latlons)) * math.Pi / 180)
+	points := make([][2]float64, len(latlons))
+	for i, latlon := range latlons {
+		points[i] = [2]float64{latlon.Lon * metresPerDegree * scale, latlon.Lat * metresPerDegree}
+	}
+
+	// split the line at the nodes which must be kept
+	keep := make([]bool, len(latlons))
+	keep[0], keep[len(keep)-1] = true, true
+	var stack [][2]int
+	for i, start := 1, 0; i < len(latlons); i++ {
+		if latlons[i].Entrance > 0 || i == len(latlons)-1 {
+			keep[i] = true
+			stack = append(stack, [2]int{start, i})
+			start = i
+		}
+	}
+
+	// keep the furthest node from each section while it is over the tolerance
+	for len(stack) > 0 {
+		a, b := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+		furthest, distance := furthestPoint(points, a, b)
+		if distance > tolerance {
+			keep[furthest] = true
+			stack = append(stack, [2]int{a, furthest}, [2]int{furthest, b})
+		}
+	}
+
+	// a ring needs 3 distinct nodes, add the furthest nodes until it has them
+	if isClosedRing(latlons) {
+		for kept(keep) < 4 {
+			best, bestDistance := -1, -1.0
+			for a, b := 0, 1; b < len(keep); b++ {
+				if !keep[b] {
+					continue
+				}
+				if furthest, distance := furthestPoint(points, a, b); furthest >= 0 && distance > bestDistance {
+					best, bestDistance = furthest, distance
+				}
+				a = b
+			}
+			keep[best] = true
+		}
+	}
+
+	simplified := make([]latLon, 0, kept(keep))
+	for i, latlon := range latlons {
+		if keep[i] {
+			simplified = append(simplified, latlon)
+		}
+	}
+	return simplified
+}
+
+// simplify - simplify the lines and rings of a shape
+func (s *shape) simplify(tolerance float64) {
+	for _, polygon := range s.polygons {
+		for i, ring := range polygon {
+			polygon[i] = simplifyLatLons(ring, tolerance)
+		}
+	}
+	for i, line := range s.lines {
+		s.lines[i] = simplifyLatLons(line, tolerance)
+	}
+}
+
+// the point between a and b which is furthest from the line between them,
+// -1 when they are adjacent
+func furthestPoint(points [][2]float64, a int, b int) (int, float64) {
+	furthest, max := -1, 0.0
+	for i := a + 1; i < b; i++ {
+		if distance := segmentDistance(points[i], points[a], points[b]); furthest < 0 || distance > max {
+			furthest, max = i, distance
+		}
+	}
+	return furthest, max
+}
+
+// the distance from p to the nearest point of the segment from a to b
+func segmentDistance(p [2]float64, a [2]float64, b [2]float64) float64 {
+	x, y := a[0], a[1]
+	dx, dy := b[0]-x, b[1]-y
+	if dx != 0 || dy != 0 {
+		t := ((p[0]-x)*dx + (p[1]-y)*dy) / (dx*dx + dy*dy)
+		if t > 1 {
+			x, y = b[0], b[1]
+		} else if t > 0 {
+			x, y = x+dx*t, y+dy*t
+		}
+	}
+	return math.Hypot(p[0]-x, p[1]-y)
+}
+
+// the number of nodes kept
+func kept(keep []bool) int {
+	n := 0
+	for _, k := range keep {
+		if k {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// about 11m of latitude
+const tenMetres = 0.0001
+
+func TestSimplifyLine(t *testing.T) {
+	line := []latLon{{Lat: 0, Lon: 0}, {Lat: tenMetres, Lon: 0.001}, {Lat: 0, Lon: 0.002}, {Lat: -tenMetres / 10, Lon: 0.003}, {Lat: 0, Lon: 0.004}}
+	assert.Equal(t, []latLon{line[0], line[1], line[2], line[4]}, simplifyLatLons(line, 5))
+	assert.Equal(t, []latLon{line[0], line[4]}, simplifyLatLons(line, 20))
+
+	// disabled, or nothing to simplify
+	assert.Equal(t, line, simplifyLatLons(line, 0))
+	assert.Equal(t, line[:2], simplifyLatLons(line[:2], 20))
+}
+
+func TestSimplifyScale(t *testing.T) {
+	// the distance is measured in metres whatever the latitude
+	line := []latLon{{Lat: 60, Lon: 0}, {Lat: 60 + tenMetres, Lon: 0.001}, {Lat: 60, Lon: 0.002}}
+	assert.Len(t, simplifyLatLons(line, 5), 3)
+	assert.Len(t, simplifyLatLons(line, 20), 2)
+
+	// a degree of longitude is half as long at 60 degrees north
+	line = []latLon{{Lat: 0, Lon: 60}, {Lat: 0.001, Lon: 60 + tenMetres*2}, {Lat: 0.002, Lon: 60}}
+	assert.Len(t, simplifyLatLons(line, 15), 3)
+	line = []latLon{{Lat: 60, Lon: 0}, {Lat: 60.001, Lon: tenMetres * 2}, {Lat: 60.002, Lon: 0}}
+	assert.Len(t, simplifyLatLons(line, 15), 2)
+}
+
+func TestSimplifyRing(t *testing.T) {
+	// a circle with a radius of about 10m
+	var ring []latLon
+	for i := 0; i < 36; i++ {
+		angle := float64(i) * math.Pi / 18
+		ring = append(ring, latLon{Lat: math.Sin(angle) * tenMetres, Lon: math.Cos(angle) * tenMetres})
+	}
+	ring = append(ring, ring[0])
+
+	simplified := simplifyLatLons(ring, 1000)
+	assert.Len(t, simplified, 4)
+	assert.True(t, isClosedRing(simplified))
+	assert.NotEqual(t, simplified[1], simplified[2])
+
+	// a small tolerance keeps more of the circle
+	assert.True(t, len(simplifyLatLons(ring, 0.5)) > 8)
+
+	// rings are never simplified below 4 nodes
+	simplified = simplifyLatLons(square(0, 0, tenMetres), 1000)
+	assert.Len(t, simplified, 4)
+	assert.True(t, isClosedRing(simplified))
+}
+
+func TestSimplifyEntrances(t *testing.T) {
+	line := []latLon{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 0.001, Entrance: 1}, {Lat: 0, Lon: 0.002}, {Lat: 0, Lon: 0.003}}
+	assert.Equal(t, []latLon{line[0], line[1], line[3]}, simplifyLatLons(line, 20))
+}
+
+func TestSimplifyShape(t *testing.T) {
+	line := []latLon{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 0.001}, {Lat: 0, Lon: 0.002}}
+	outer := square(0, 0, 1)
+	s := &shape{polygons: [][][]latLon{{outer}}, lines: [][]latLon{line}}
+	s.simplify(20)
+	assert.Equal(t, [][]latLon{{line[0], line[2]}}, s.lines)
+	assert.Equal(t, outer, s.polygons[0][0])
+
+	// the original nodes are not modified
+	assert.Len(t, line, 3)
+}
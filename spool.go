@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// openInput - open the PBF input, the path '-' reads from stdin.
+// note: the decoding passes need to rewind the file, so non-seekable
+// input (such as a pipe) is first spooled to a temporary file in spoolDir.
+func openInput(path string, spoolDir string) *os.File {
+	if path != "-" {
+		return openFile(path)
+	}
+
+	// stdin was redirected from a regular file, it can be used as-is
+	if isSeekable(os.Stdin) {
+		return os.Stdin
+	}
+
+	return spool(os.Stdin, spoolDir)
+}
+
+// check if a file supports rewinding
+func isSeekable(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	_, err = file.Seek(0, io.SeekCurrent)
+	return err == nil
+}
+
+// countingWriter - keep a running total of the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// interruptibleReader - stop reading at the next call once a signal was caught
+type interruptibleReader struct {
+	r io.Reader
+}
+
+func (i interruptibleReader) Read(p []byte) (int, error) {
+	checkInterrupted()
+	return i.r.Read(p)
+}
+
+// the name of spooled copies of stdin, which are not counted as part of the cache
+const spoolPrefix = "pbf2json-spool-"
+
+// copy src to a temporary file in dir and rewind it, ready for decoding.
+// the file is removed when the process exits.
+func spool(src io.Reader, dir string) *os.File {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fatal(err)
+	}
+
+	file, err := ioutil.TempFile(dir, spoolPrefix+"*.pbf")
+	if err != nil {
+		fatal(err)
+	}
+	addCleanup(func() error {
+		file.Close()
+		return os.Remove(file.Name())
+	})
+
+	log.Println("[info] spooling stdin to:", file.Name())
+	counter := &countingWriter{w: file}
+	if _, err := io.Copy(counter, interruptibleReader{src}); err != nil {
+		fatalf("[error] spooling stdin failed after %d bytes: %v", counter.n, err)
+	}
+	log.Println("[info] spooled", counter.n, "bytes from stdin")
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		fatal(err)
+	}
+	return file
+}
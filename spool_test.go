@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSeekable(t *testing.T) {
+
+	// regular files can be rewound
+	file, err := ioutil.TempFile(t.TempDir(), "seekable")
+	assert.Nil(t, err)
+	defer file.Close()
+	assert.True(t, isSeekable(file))
+
+	// pipes can not
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	defer r.Close()
+	defer w.Close()
+	assert.False(t, isSeekable(r))
+}
+
+func TestSpool(t *testing.T) {
+
+	var dir = filepath.Join(t.TempDir(), "nested")
+	var data = bytes.Repeat([]byte("pbf"), 100000)
+
+	file := spool(bytes.NewReader(data), dir)
+	defer file.Close()
+
+	// spool file is created in the requested directory
+	assert.Equal(t, dir, filepath.Dir(file.Name()))
+
+	// file is rewound and contains the full input
+	actual, err := ioutil.ReadAll(file)
+	assert.Nil(t, err)
+	assert.Equal(t, data, actual)
+}
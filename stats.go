@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reasons an element of interest was not printed
+const (
+	skipRelationWithoutWays = "relation_without_ways"     // relation has no member ways
+	skipWayMissingNodes     = "way_missing_nodes"         // way refs not found in the cache
+	skipRelationNoWays      = "relation_no_ways"          // no member ways found in the cache
+	skipRelationNoBounds    = "relation_no_bounds"        // no member way had valid bounds
+	skipMemberWayNoBounds   = "relation_member_no_bounds" // a single member way had no valid bounds
+	skipDuplicate           = "duplicate"                 // a newer copy exists in another input file
+	skipFormat              = "format_rejected"           // the output format can not represent the record
+)
+
+// stats - counters collected over the whole run, summarised when it ends
+type stats struct {
+	Seen        map[string]int64  `json:"seen"`    // elements decoded while indexing, by type
+	Matched     map[string]int64  `json:"matched"` // elements matching the tag conditions, by type
+	Emitted     map[string]int64  `json:"emitted"` // records printed, by type
+	Skipped     map[string]int64  `json:"skipped"` // elements not printed, by reason
+	Partial     map[string]int64  `json:"partial"` // records printed with missing references, by type
+	CacheWrites int64             `json:"cache_writes"`
+	CacheBytes  int64             `json:"cache_bytes"`
+	Masks       map[string]uint64 `json:"masks"` // element IDs recorded in each bitmask
+	Passes      []*passStats      `json:"passes"`
+	progress    *progress
+}
+
+// passStats - timing of a single pass over the input files
+type passStats struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"-"`
+	Seconds  float64       `json:"seconds"`
+	started  time.Time
+}
+
+// newStats - constructor
+func newStats(progress *progress) *stats {
+	return &stats{
+		Seen:     make(map[string]int64),
+		Matched:  make(map[string]int64),
+		Emitted:  make(map[string]int64),
+		Skipped:  make(map[string]int64),
+		Partial:  make(map[string]int64),
+		Masks:    make(map[string]uint64),
+		progress: progress,
+	}
+}
+
+// StartPass - record the start of a pass over files
+func (s *stats) StartPass(name string, files []*os.File) {
+	s.Passes = append(s.Passes, &passStats{Name: name, started: time.Now()})
+	s.progress.Start(name, files)
+}
+
+// FinishPass - record the end of the current pass
+func (s *stats) FinishPass() {
+	pass := s.Passes[len(s.Passes)-1]
+	pass.Duration = time.Since(pass.started)
+	pass.Seconds = pass.Duration.Seconds()
+	s.progress.Finish()
+}
+
+// Reader - wrap a reader to report the bytes read during this pass
+func (s *stats) Reader(r io.Reader) io.Reader {
+	return s.progress.Reader(r)
+}
+
+// Element - record an element decoded during the current pass
+func (s *stats) Element() {
+	s.progress.Element()
+}
+
+// CountSeen - record an element decoded while indexing
+func (s *stats) CountSeen(kind string) {
+	s.Seen[kind]++
+}
+
+// CountMatched - record an element matching the tag conditions
+func (s *stats) CountMatched(kind string) {
+	s.Matched[kind]++
+}
+
+// CountEmitted - record a record printed
+func (s *stats) CountEmitted(kind string) {
+	s.Emitted[kind]++
+	s.progress.Emitted()
+}
+
+// CountSkipped - record an element which was not printed
+func (s *stats) CountSkipped(reason string) {
+	s.Skipped[reason]++
+}
+
+// CountPartial - record a record printed with missing references
+func (s *stats) CountPartial(kind string) {
+	s.Partial[kind]++
+}
+
+// CountCached - record an element queued for writing to leveldb
+func (s *stats) CountCached() {
+	s.CacheWrites++
+	s.progress.Cached()
+}
+
+// Measure - record the final size of the cache and the bitmasks
+func (s *stats) Measure(cachePath string, masks *BitmaskMap) {
+	s.CacheBytes = dirSize(cachePath)
+	s.Masks = masks.Sizes()
+}
+
+// Print - write a summary of the run to stderr
+func (s *stats) Print() {
+	log.Println("[info] summary: seen", formatCounts(s.Seen))
+	log.Println("[info] summary: matched", formatCounts(s.Matched))
+	log.Println("[info] summary: emitted", formatCounts(s.Emitted))
+	log.Println("[info] summary: skipped", formatCounts(s.Skipped))
+	log.Println("[info] summary: partial", formatCounts(s.Partial))
+	log.Printf("[info] summary: cache %s, %d writes\n", formatBytes(s.CacheBytes), s.CacheWrites)
+
+	masks := make(map[string]int64, len(s.Masks))
+	for name, size := range s.Masks {
+		masks[name] = int64(size)
+	}
+	log.Println("[info] summary: masks", formatCounts(masks))
+
+	for _, pass := range s.Passes {
+		log.Printf("[info] summary: pass %s took %s\n", pass.Name, pass.Duration.Round(time.Millisecond))
+	}
+}
+
+// render counters as a sorted list, eg. 'node=10 way=2'
+func formatCounts(counts map[string]int64) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	var keys []string
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%d", key, counts[key]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// total size of all files in a directory, excluding the temporary copies of
+// input and output files which are written alongside the cache
+func dirSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		if name := info.Name(); strings.HasPrefix(name, spoolPrefix) || strings.HasPrefix(name, fgbSpillPrefix) {
+			return nil
+		}
+		size += info.Size()
+		return nil
+	})
+	return size
+}
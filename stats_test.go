@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatCounts(t *testing.T) {
+	assert.Equal(t, "none", formatCounts(map[string]int64{}))
+	assert.Equal(t, "node=10 relation=1 way=2", formatCounts(map[string]int64{"way": 2, "node": 10, "relation": 1}))
+}
+
+func TestStatsCounters(t *testing.T) {
+
+	// progress reporting is optional
+	var s = newStats(nil)
+	s.CountSeen("node")
+	s.CountSeen("node")
+	s.CountMatched("way")
+	s.CountEmitted("way")
+	s.CountSkipped(skipWayMissingNodes)
+	s.CountCached()
+
+	assert.Equal(t, int64(2), s.Seen["node"])
+	assert.Equal(t, int64(1), s.Matched["way"])
+	assert.Equal(t, int64(1), s.Emitted["way"])
+	assert.Equal(t, int64(1), s.Skipped[skipWayMissingNodes])
+	assert.Equal(t, int64(1), s.CacheWrites)
+}
+
+func TestStatsPasses(t *testing.T) {
+
+	var s = newStats(nil)
+	s.StartPass("index", nil)
+	s.FinishPass()
+	s.StartPass("print", nil)
+	s.FinishPass()
+
+	assert.Len(t, s.Passes, 2)
+	assert.Equal(t, "index", s.Passes[0].Name)
+	assert.Equal(t, "print", s.Passes[1].Name)
+}
+
+func TestStatsMeasure(t *testing.T) {
+
+	var dir = t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "a"), make([]byte, 100), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b"), make([]byte, 50), 0644)
+	ioutil.WriteFile(filepath.Join(dir, spoolPrefix+"1.pbf"), make([]byte, 1000), 0644)
+	ioutil.WriteFile(filepath.Join(dir, fgbSpillPrefix+"1.fgb"), make([]byte, 1000), 0644)
+
+	var masks = NewBitmaskMap()
+	masks.Nodes.Insert(1)
+	masks.Nodes.Insert(2)
+	masks.Ways.Insert(3)
+
+	var s = newStats(nil)
+	s.Measure(dir, masks)
+	assert.Equal(t, int64(150), s.CacheBytes)
+	assert.Equal(t, uint64(2), s.Masks["Nodes"])
+	assert.Equal(t, uint64(1), s.Masks["Ways"])
+	assert.Equal(t, uint64(0), s.Masks["Relations"])
+}
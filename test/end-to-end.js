@@ -18,14 +18,12 @@ var workdir = fs.mkdtempSync( path.join( os.tmpdir(), 'pbf2json-e2e-' ) );
 function test( name, tags, cb ){
 
   var tmpfile = path.join( workdir, name + '.json' ),
-      leveldbDir = fs.mkdtempSync( path.join( workdir, 'leveldb-' ) ),
       pbfPath = path.resolve(__dirname) + '/vancouver_canada.osm.pbf',
       expectedPath = path.resolve(__dirname) + '/fixtures/' + name + '.json',
       actual = {};
 
-  // give each test its own leveldb directory, the default of '/tmp' is shared
-  // between concurrent runs and leaves its files behind
-  pbf2json.createReadStream({ file: pbfPath, tags: tags, leveldb: leveldbDir })
+  // each run creates (and removes) its own leveldb directory by default
+  pbf2json.createReadStream({ file: pbfPath, tags: tags })
     .pipe( through.obj( function( obj, _, next ){
       obj.gid = obj.type + ':' + obj.id;
       actual[ obj.gid ] = obj;
@@ -35,7 +33,6 @@ function test( name, tags, cb ){
 
       // write actual to disk, so failures can be inspected by hand
       fs.writeFileSync( tmpfile, JSON.stringify( actual, null, 2 ) );
-      fs.rmSync( leveldbDir, { recursive: true, force: true } );
 
       var expected = JSON.parse( fs.readFileSync( expectedPath, { encoding: 'utf8' } ) );
 
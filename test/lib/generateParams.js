@@ -13,6 +13,16 @@ module.exports.tests.params = function(test) {
     t.equal(params[params.length - 1], '/some/path/to/osm.pbf', 'final parameter is path to PBF file');
     t.end();
   });
+  test('multiple PBF files', function(t) {
+    const config = {
+      file: [ '/some/path/to/a.osm.pbf', '/some/path/to/b.osm.pbf' ]
+    };
+
+    const params = generateParams(config);
+
+    t.deepEqual(params.slice(-2), config.file, 'final parameters are paths to PBF files');
+    t.end();
+  });
   test('PBF file', function(t) {
     const config = {
       tags: [
@@ -42,6 +52,260 @@ module.exports.tests.params = function(test) {
     t.equal(params[0], expected, 'waynodes is serialized into parameter');
     t.end();
   });
+
+  test('keepCache', function(t) {
+    const config = {
+      keepCache: true
+    };
+
+    const params = generateParams(config);
+
+    t.equal(params[0], '-keep-cache', 'keepCache is serialized into parameter');
+    t.end();
+  });
+
+  test('metadata', function(t) {
+    const config = {
+      metadata: ['version', 'timestamp']
+    };
+
+    const params = generateParams(config);
+
+    t.equal(params[0], '-metadata=version,timestamp', 'metadata is serialized into parameter');
+    t.end();
+  });
+
+  test('config', function(t) {
+    const config = {
+      config: '/tmp/pbf2json.json'
+    };
+
+    const params = generateParams(config);
+
+    t.equal(params[0], '-config=/tmp/pbf2json.json', 'config is serialized into parameter');
+    t.end();
+  });
+
+  test('geometry', function(t) {
+    const config = {
+      waynodes: true,
+      geometry: 'polyline6'
+    };
+
+    const params = generateParams(config);
+
+    t.deepEqual(params.slice(0, 2), [
+      '--waynodes=true',
+      '-geometry=polyline6'
+    ], 'geometry is serialized into parameter');
+    t.end();
+  });
+
+  test('simplify', function(t) {
+    const config = {
+      geometry: 'wkt',
+      simplify: 2.5
+    };
+
+    const params = generateParams(config);
+
+    t.deepEqual(params.slice(0, 2), [
+      '-geometry=wkt',
+      '-simplify=2.5'
+    ], 'simplify is serialized into parameter');
+    t.end();
+  });
+
+  test('normaliseTags', function(t) {
+    const config = {
+      normaliseTags: true
+    };
+
+    const params = generateParams(config);
+
+    t.equal(params[0], '-normalise-tags', 'normaliseTags is serialized into parameter');
+    t.end();
+  });
+
+  test('output', function(t) {
+    const config = {
+      output: '/tmp/out',
+      split: 'type',
+      shards: 4,
+      rotateRecords: 1000,
+      rotateBytes: 1048576
+    };
+
+    const params = generateParams(config);
+
+    t.deepEqual(params.slice(0, 5), [
+      '-output=/tmp/out',
+      '-split=type',
+      '-shards=4',
+      '-rotate-records=1000',
+      '-rotate-bytes=1048576'
+    ], 'output options are serialized into parameters');
+    t.end();
+  });
+
+  test('compress', function(t) {
+    const config = {
+      compress: 'zstd'
+    };
+
+    const params = generateParams(config);
+
+    t.equal(params[0], '-compress=zstd', 'compress is serialized into parameter');
+    t.end();
+  });
+
+  test('format and esIndex', function(t) {
+    const config = {
+      format: 'esbulk',
+      esIndex: 'osm'
+    };
+
+    const params = generateParams(config);
+
+    t.deepEqual(params.slice(0, 2), [
+      '-format=esbulk',
+      '-es-index=osm'
+    ], 'format and esIndex are serialized into parameters');
+    t.end();
+  });
+
+  test('peliasConfig', function(t) {
+    const config = {
+      format: 'pelias',
+      peliasConfig: '/tmp/pelias.json'
+    };
+
+    const params = generateParams(config);
+
+    t.deepEqual(params.slice(0, 2), [
+      '-format=pelias',
+      '-pelias-config=/tmp/pelias.json'
+    ], 'peliasConfig is serialized into parameter');
+    t.end();
+  });
+
+  test('columns', function(t) {
+    const config = {
+      format: 'csv',
+      columns: [ 'id', 'type', 'name', 'addr:street' ]
+    };
+
+    const params = generateParams(config);
+
+    t.deepEqual(params.slice(0, 2), [
+      '-format=csv',
+      '-columns=id,type,name,addr:street'
+    ], 'columns are serialized into parameter');
+    t.end();
+  });
+
+  test('fgbIndex', function(t) {
+    const config = {
+      format: 'fgb',
+      fgbIndex: false
+    };
+
+    const params = generateParams(config);
+
+    t.deepEqual(params.slice(0, 2), [
+      '-format=fgb',
+      '-fgb-index=false'
+    ], 'fgbIndex is serialized into parameter');
+    t.end();
+  });
+
+  test('pgTags', function(t) {
+    const config = {
+      format: 'pgcopy',
+      pgTags: 'hstore'
+    };
+
+    const params = generateParams(config);
+
+    t.deepEqual(params.slice(0, 2), [
+      '-format=pgcopy',
+      '-pg-tags=hstore'
+    ], 'pgTags is serialized into parameter');
+    t.end();
+  });
+
+  test('schema and precision', function(t) {
+    const config = {
+      schema: 2,
+      precision: 0
+    };
+
+    const params = generateParams(config);
+
+    t.deepEqual(params.slice(0, 2), [
+      '-schema=2',
+      '-precision=0'
+    ], 'schema and precision are serialized into parameters');
+    t.end();
+  });
+
+  test('keepTags and dropTags', function(t) {
+    const config = {
+      keepTags: ['name', 'addr:*'],
+      dropTags: 'tiger:*'
+    };
+
+    const params = generateParams(config);
+
+    t.equal(params[0], '-keep-tags=name,addr:*', 'keepTags is serialized into parameter');
+    t.equal(params[1], '-drop-tags=tiger:*', 'dropTags is serialized into parameter');
+    t.end();
+  });
+
+  test('transform', function(t) {
+    const config = {
+      transform: '/tmp/transform.json'
+    };
+
+    const params = generateParams(config);
+
+    t.equal(params[0], '-transform=/tmp/transform.json', 'transform is serialized into parameter');
+    t.end();
+  });
+
+  test('metadata filters', function(t) {
+    const config = {
+      newerThan: '2020-01-01',
+      olderThan: '2021-01-01',
+      user: ['alice', 'bob'],
+      uid: 1234,
+      minVersion: 2
+    };
+
+    const params = generateParams(config);
+
+    t.deepEqual(params.slice(0, 5), [
+      '-newer-than=2020-01-01',
+      '-older-than=2021-01-01',
+      '-user=alice,bob',
+      '-uid=1234',
+      '-min-version=2'
+    ], 'metadata filters are serialized into parameters');
+    t.end();
+  });
+
+  test('missing', function(t) {
+    const config = {
+      missing: 'skip-refs',
+      missingReport: '/tmp/missing.ndjson'
+    };
+
+    const params = generateParams(config);
+
+    t.equal(params[0], '-missing=skip-refs', 'missing is serialized into parameter');
+    t.equal(params[1], '-missing-report=/tmp/missing.ndjson', 'missingReport is serialized into parameter');
+    t.end();
+  });
 };
 
 module.exports.all = function (tape, common) {
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// transform operations
+const (
+	opNFC       = "nfc"       // unicode NFC normalisation of keys and values
+	opRename    = "rename"    // rename a key
+	opLowercase = "lowercase" // lowercase the values of matching keys
+	opSplit     = "split"     // split the values of matching keys in to arrays
+	opDerive    = "derive"    // add a tag derived from other tags
+)
+
+// transformStep - a single step of the transform pipeline
+type transformStep struct {
+	Op        string   `json:"op"`
+	From      string   `json:"from,omitempty"`      // rename: the original key
+	To        string   `json:"to,omitempty"`        // rename: the new key
+	Keys      []string `json:"keys,omitempty"`      // lowercase, split: glob patterns
+	Separator string   `json:"separator,omitempty"` // split: defaults to ';'
+	Key       string   `json:"key,omitempty"`       // derive: the key to add
+	Sources   []string `json:"sources,omitempty"`   // derive: keys to copy from, first found wins
+	Value     string   `json:"value,omitempty"`     // derive: a fixed value, set when any source is found
+}
+
+// tagTransform - a pipeline of steps applied to the tags of each record after
+// matching. Values are split in to arrays once all other steps have run.
+// note: a nil *tagTransform is valid and leaves tags unchanged.
+type tagTransform struct {
+	Steps []transformStep `json:"steps"`
+}
+
+// loadTransform - read a JSON transform config, returns nil if no path is specified
+func loadTransform(path string) (*tagTransform, error) {
+	if len(path) < 1 {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseTransform(data)
+}
+
+// parseTransform - decode and validate a JSON transform config
+func parseTransform(data []byte) (*tagTransform, error) {
+	t := &tagTransform{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("invalid transform config: %v", err)
+	}
+	for i, step := range t.Steps {
+		if err := step.validate(); err != nil {
+			return nil, fmt.Errorf("invalid transform step %d: %v", i+1, err)
+		}
+	}
+	return t, nil
+}
+
+func (s transformStep) validate() error {
+	switch s.Op {
+	case opNFC:
+	case opRename:
+		if s.From == "" || s.To == "" {
+			return fmt.Errorf("rename requires 'from' and 'to'")
+		}
+	case opLowercase, opSplit:
+		if len(s.Keys) == 0 {
+			return fmt.Errorf("%s requires 'keys'", s.Op)
+		}
+		if _, err := parseTagPatterns(strings.Join(s.Keys, ",")); err != nil {
+			return err
+		}
+	case opDerive:
+		if s.Key == "" || len(s.Sources) == 0 {
+			return fmt.Errorf("derive requires 'key' and 'sources'")
+		}
+	default:
+		return fmt.Errorf("unknown op '%s'", s.Op)
+	}
+	return nil
+}
+
+// Apply - run every step except splitting on the tags
+func (t *tagTransform) Apply(tags map[string]string) map[string]string {
+	if t == nil {
+		return tags
+	}
+	for _, step := range t.Steps {
+		switch step.Op {
+		case opNFC:
+			tags = normaliseTags(tags)
+		case opRename:
+			renameTag(tags, step.From, step.To)
+		case opLowercase:
+			lowercaseValues(tags, step.Keys)
+		case opDerive:
+			deriveTag(tags, step.Key, step.Sources, step.Value)
+		}
+	}
+	return tags
+}
+
+// Split - split multi-values in to arrays, the result is ready for encoding
+func (t *tagTransform) Split(tags map[string]string) interface{} {
+	if t == nil {
+		return tags
+	}
+	var split map[string]interface{}
+	for _, step := range t.Steps {
+		if step.Op != opSplit {
+			continue
+		}
+		if split == nil {
+			split = make(map[string]interface{}, len(tags))
+			for k, v := range tags {
+				split[k] = v
+			}
+		}
+		separator := step.Separator
+		if separator == "" {
+			separator = ";"
+		}
+		splitValues(split, step.Keys, separator)
+	}
+	if split == nil {
+		return tags
+	}
+	return split
+}
+
+// normaliseTags - unicode NFC normalisation of keys and values
+func normaliseTags(tags map[string]string) map[string]string {
+	normalised := make(map[string]string, len(tags))
+	for k, v := range tags {
+		normalised[norm.NFC.String(k)] = norm.NFC.String(v)
+	}
+	return normalised
+}
+
+// renameTag - move a value to a new key, replacing any existing value
+func renameTag(tags map[string]string, from string, to string) {
+	if val, ok := tags[from]; ok {
+		delete(tags, from)
+		tags[to] = val
+	}
+}
+
+// lowercaseValues - lowercase the values of keys matching the patterns
+func lowercaseValues(tags map[string]string, patterns []string) {
+	for k, v := range tags {
+		if matchTagPatterns(patterns, k) {
+			tags[k] = strings.ToLower(v)
+		}
+	}
+}
+
+// deriveTag - add a tag from the first source key found, or a fixed
+// value if one is specified. Existing tags are never replaced.
+func deriveTag(tags map[string]string, key string, sources []string, value string) {
+	if _, ok := tags[key]; ok {
+		return
+	}
+	for _, source := range sources {
+		if val, ok := tags[source]; ok {
+			if value != "" {
+				val = value
+			}
+			tags[key] = val
+			return
+		}
+	}
+}
+
+// splitValues - split string values of keys matching the patterns in to
+// arrays, empty parts are discarded
+func splitValues(tags map[string]interface{}, patterns []string, separator string) {
+	for k, v := range tags {
+		val, ok := v.(string)
+		if !ok || !matchTagPatterns(patterns, k) {
+			continue
+		}
+		values := make([]string, 0)
+		for _, part := range strings.Split(val, separator) {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+		tags[k] = values
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTransform(t *testing.T) {
+	transform, err := parseTransform([]byte(`{"steps":[
+		{"op":"nfc"},
+		{"op":"rename","from":"addr:street","to":"street"},
+		{"op":"lowercase","keys":["amenity"]},
+		{"op":"split","keys":["cuisine"]},
+		{"op":"derive","key":"name:default","sources":["name:en","name"]}
+	]}`))
+	assert.Nil(t, err)
+	assert.Len(t, transform.Steps, 5)
+
+	// invalid configs
+	_, err = parseTransform([]byte(`{"steps":[{"op":"explode"}]}`))
+	assert.NotNil(t, err)
+	_, err = parseTransform([]byte(`{"steps":[{"op":"rename","from":"a"}]}`))
+	assert.NotNil(t, err)
+	_, err = parseTransform([]byte(`{"steps":[{"op":"split"}]}`))
+	assert.NotNil(t, err)
+	_, err = parseTransform([]byte(`{"steps":[{"op":"derive","key":"a"}]}`))
+	assert.NotNil(t, err)
+	_, err = parseTransform([]byte(`{"steps":`))
+	assert.NotNil(t, err)
+}
+
+func TestNormaliseTags(t *testing.T) {
+	// 'e' followed by a combining acute accent
+	tags := normaliseTags(map[string]string{"name": "Cafe\u0301"})
+	assert.Equal(t, map[string]string{"name": "Caf\u00e9"}, tags)
+}
+
+func TestRenameTag(t *testing.T) {
+	tags := map[string]string{"addr:street": "Main St"}
+	renameTag(tags, "addr:street", "street")
+	assert.Equal(t, map[string]string{"street": "Main St"}, tags)
+
+	// missing keys are ignored
+	renameTag(tags, "addr:city", "city")
+	assert.Equal(t, map[string]string{"street": "Main St"}, tags)
+}
+
+func TestLowercaseValues(t *testing.T) {
+	tags := map[string]string{"amenity": "Cafe", "name": "Cafe", "cuisine:type": "ITALIAN"}
+	lowercaseValues(tags, []string{"amenity", "cuisine:*"})
+	assert.Equal(t, map[string]string{"amenity": "cafe", "name": "Cafe", "cuisine:type": "italian"}, tags)
+}
+
+func TestDeriveTag(t *testing.T) {
+
+	// first source found wins
+	tags := map[string]string{"name": "Wellington", "name:mi": "Te Whanganui-a-Tara"}
+	deriveTag(tags, "label", []string{"name:en", "name", "name:mi"}, "")
+	assert.Equal(t, "Wellington", tags["label"])
+
+	// existing tags are not replaced
+	deriveTag(tags, "label", []string{"name:mi"}, "")
+	assert.Equal(t, "Wellington", tags["label"])
+
+	// fixed value
+	deriveTag(tags, "has_name", []string{"name"}, "yes")
+	assert.Equal(t, "yes", tags["has_name"])
+
+	// no source found
+	deriveTag(tags, "ref", []string{"ref:nz"}, "")
+	_, ok := tags["ref"]
+	assert.False(t, ok)
+}
+
+func TestSplitValues(t *testing.T) {
+	tags := map[string]interface{}{"cuisine": "pizza; burger;;", "name": "A;B"}
+	splitValues(tags, []string{"cuisine"}, ";")
+	assert.Equal(t, map[string]interface{}{"cuisine": []string{"pizza", "burger"}, "name": "A;B"}, tags)
+}
+
+func TestTagTransform(t *testing.T) {
+
+	// nil transform leaves tags unchanged
+	var transform *tagTransform
+	tags := map[string]string{"amenity": "Cafe"}
+	assert.Equal(t, tags, transform.Apply(tags))
+	assert.Equal(t, tags, transform.Split(tags))
+
+	// split runs after all other steps
+	transform, _ = parseTransform([]byte(`{"steps":[
+		{"op":"split","keys":["cuisine"]},
+		{"op":"rename","from":"cuisine:type","to":"cuisine"},
+		{"op":"lowercase","keys":["amenity","cuisine"]}
+	]}`))
+	tags = transform.Apply(map[string]string{"amenity": "Cafe", "cuisine:type": "Pizza;Burger"})
+	assert.Equal(t, map[string]string{"amenity": "cafe", "cuisine": "pizza;burger"}, tags)
+	assert.Equal(t, map[string]interface{}{"amenity": "cafe", "cuisine": []string{"pizza", "burger"}}, transform.Split(tags))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+
+	"github.com/paulmach/go.geo"
+)
+
+// WKB geometry types
+const (
+	wkbPoint      = 1
+	wkbLineString = 2
+	wkbPolygon    = 3
+)
+
+// the EWKB flag of geometries which include an SRID
+const ewkbSRID = 0x20000000
+
+// append the byte order and type of a little endian WKB geometry, geometries
+// with an srid are written as PostGIS EWKB
+func appendWKBHeader(b []byte, geometryType uint32, srid uint32) []byte {
+	b = append(b, 1) // little endian
+	if srid == 0 {
+		return appendUint32(b, geometryType)
+	}
+	b = appendUint32(b, geometryType|ewkbSRID)
+	return appendUint32(b, srid)
+}
+
+// append a point as WKB
+func appendWKBPoint(b []byte, lon float64, lat float64, srid uint32) []byte {
+	b = appendWKBHeader(b, wkbPoint, srid)
+	b = appendFloat64(b, lon)
+	return appendFloat64(b, lat)
+}
+
+// append a way as WKB, closed ways are polygons and open ways are lines
+func appendWKBWay(b []byte, nodes []latLon, srid uint32) []byte {
+	if isClosedRing(nodes) {
+		b = appendWKBHeader(b, wkbPolygon, srid)
+		b = appendUint32(b, 1) // a single ring
+	} else {
+		b = appendWKBHeader(b, wkbLineString, srid)
+	}
+	b = appendUint32(b, uint32(len(nodes)))
+	for _, node := range nodes {
+		b = appendFloat64(b, node.Lon)
+		b = appendFloat64(b, node.Lat)
+	}
+	return b
+}
+
+// isClosedRing - whether a way is a polygon, it ends where it starts and
+// has at least 3 distinct nodes
+func isClosedRing(nodes []latLon) bool {
+	first, last := nodes[0], nodes[len(nodes)-1]
+	return len(nodes) > 3 && first.Lat == last.Lat && first.Lon == last.Lon
+}
+
+// append bounds as a WKB polygon, counter-clockwise from the south west corner
+func appendWKBBounds(b []byte, bounds *geo.Bound, srid uint32) []byte {
+	b = appendWKBHeader(b, wkbPolygon, srid)
+	b = appendUint32(b, 1)
+	b = appendUint32(b, 5)
+	for _, corner := range [5][2]float64{
+		{bounds.West(), bounds.South()},
+		{bounds.East(), bounds.South()},
+		{bounds.East(), bounds.North()},
+		{bounds.West(), bounds.North()},
+		{bounds.West(), bounds.South()},
+	} {
+		b = appendFloat64(b, corner[0])
+		b = appendFloat64(b, corner[1])
+	}
+	return b
+}
+
+// append the hex encoding of data
+func appendHex(b []byte, data []byte) []byte {
+	for _, c := range data {
+		b = append(b, hexDigits[c>>4], hexDigits[c&0xF])
+	}
+	return b
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendFloat64(b []byte, f float64) []byte {
+	return appendUint64(b, math.Float64bits(f))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24), byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}